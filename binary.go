@@ -0,0 +1,116 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+)
+
+// WriteBinary writes the tree to `w` in a compact binary format meant for
+// trees of `int` keys: the number of entries as a uvarint, then for each
+// entry in ascending key order, the key as a varint followed by the length
+// of the encoded payload as a uvarint & the encoded payload itself.
+// Payloads are opaque to the tree, so `encodeValue` must turn each one
+// into bytes; ReadBinary needs the matching decoder. A key which is not
+// an `int` fails with ErrorKeyNotInt.
+func (t *Tree) WriteBinary(w io.Writer, encodeValue func(interface{}) ([]byte, error)) error {
+    buf := bufio.NewWriter(w)
+    scratch := make([]byte, binary.MaxVarintLen64)
+
+    size := t.Size()
+    if _, err := buf.Write(scratch[:binary.PutUvarint(scratch, size)]); err != nil {
+        return err
+    }
+    var err error
+    inorder(t.root, func(n *Node) bool {
+        key, ok := n.key.(int)
+        if !ok {
+            err = ErrorKeyNotInt
+            return false
+        }
+        var value []byte
+        if value, err = encodeValue(n.payload); err != nil {
+            return false
+        }
+        if _, err = buf.Write(scratch[:binary.PutVarint(scratch, int64(key))]); err != nil {
+            return false
+        }
+        if _, err = buf.Write(scratch[:binary.PutUvarint(scratch, uint64(len(value)))]); err != nil {
+            return false
+        }
+        _, err = buf.Write(value)
+        return err == nil
+    })
+    if err != nil {
+        return err
+    }
+    return buf.Flush()
+}
+
+// ReadBinary builds a tree ordered by `cmp` from the format written by
+// WriteBinary, turning payloads back with `decodeValue`. The entries being
+// stored in ascending order, the tree is bulk-loaded balanced. Input which
+// ends early fails with io.ErrUnexpectedEOF; a payload length no reader
+// could hold fails with ErrorCorruptInput. Payloads are read into buffers
+// growing with the bytes actually read, so a corrupt length cannot make
+// it allocate more than the input holds. Like ReadJSONL, it expects
+// `cmp` resolved by the caller, e.g. through LookupComparator.
+func ReadBinary(r io.Reader, cmp Comparator, decodeValue func([]byte) (interface{}, error)) (*Tree, error) {
+    buf := bufio.NewReader(r)
+    size, err := binary.ReadUvarint(buf)
+    if err != nil {
+        return nil, err
+    }
+    var keys, values []interface{}
+    for i := uint64(0); i < size; i++ {
+        key, err := binary.ReadVarint(buf)
+        if err != nil {
+            return nil, unexpectedEOF(err)
+        }
+        length, err := binary.ReadUvarint(buf)
+        if err != nil {
+            return nil, unexpectedEOF(err)
+        }
+        if length > math.MaxInt64 {
+            return nil, fmt.Errorf("%w: payload of entry %d is %d bytes long", ErrorCorruptInput, i, length)
+        }
+        var encoded bytes.Buffer
+        if _, err := io.CopyN(&encoded, buf, int64(length)); err != nil {
+            return nil, unexpectedEOF(err)
+        }
+        value, err := decodeValue(encoded.Bytes())
+        if err != nil {
+            return nil, err
+        }
+        keys, values = append(keys, int(key)), append(values, value)
+    }
+    return NewTreeFromSlices(cmp, keys, values)
+}
+
+// unexpectedEOF reports input ending before all entries were read.
+func unexpectedEOF(err error) error {
+    if err == io.EOF {
+        return io.ErrUnexpectedEOF
+    }
+    return err
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+// Builder fills a tree through chained calls, e.g. in tests:
+//
+//    tree := NewBuilder(IntComparator).Put(1, "a").Put(2, "b").Build()
+//
+// The first error met, e.g. an invalid key, is kept & reported by Err;
+// the calls after it are ignored.
+type Builder struct {
+    tree *Tree
+    err  error
+}
+
+// NewBuilder returns a builder of a tree ordered by `cmp`, configured
+// by `options` as in NewTreeWith.
+func NewBuilder(cmp Comparator, options ...Option) *Builder {
+    return &Builder{tree: NewTreeWith(cmp, options...)}
+}
+
+// Put maps `key` to `value` like Tree.Put.
+func (b *Builder) Put(key, value interface{}) *Builder {
+    if b.err == nil {
+        b.err = b.tree.Put(key, value)
+    }
+    return b
+}
+
+// BulkSorted adds `entries`. When nothing was added before & the keys are
+// strictly ascending, the tree is bulk-loaded balanced in O(n); otherwise
+// the entries are put in turn. Either way, entries are checked as by Put:
+// a bulk load fails before adding anything.
+func (b *Builder) BulkSorted(entries []Entry) *Builder {
+    if b.err != nil {
+        return b
+    }
+    keys := make([]interface{}, len(entries))
+    values := make([]interface{}, len(entries))
+    for i, entry := range entries {
+        if b.err = mustBeValidKey(entry.Key); b.err != nil {
+            return b
+        }
+        keys[i], values[i] = entry.Key, entry.Value
+    }
+    if isNil(b.tree.root) {
+        if b.err = b.tree.checkBulk(keys, values); b.err != nil {
+            return b
+        }
+        if isStrictlyAscending(b.tree.cmp, keys) {
+            b.tree.loadSorted(keys, values)
+            return b
+        }
+    }
+    for _, entry := range entries {
+        b.Put(entry.Key, entry.Value)
+    }
+    return b
+}
+
+// Err returns the first error met while building, or nil.
+func (b *Builder) Err() error {
+    return b.err
+}
+
+// Build returns the tree built so far. The builder must not be used
+// afterwards, as the tree is not copied.
+func (b *Builder) Build() *Tree {
+    return b.tree
+}
@@ -0,0 +1,493 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import (
+    "container/heap"
+    "reflect"
+)
+
+// emptyCopy returns an empty tree configured like t.
+func (t *Tree) emptyCopy() *Tree {
+    c := *t
+    c.sentinel = nil
+    c.fixupTrace = nil
+    c.spare = nil
+    c.minNode, c.maxNode = nil, nil
+    if t.counters != nil {
+        c.counters = &OpCounters{}
+    }
+    c.root = c.leaf()
+    return &c
+}
+
+// buildSorted replaces the content of the tree with the mapping of
+// keys[i] to payloads[i]. The keys must be strictly ascending according
+// to the comparator of the tree. The result is balanced in O(n): every
+// level is black, except for the deepest one when it is partially filled.
+func (t *Tree) buildSorted(keys, payloads []interface{}) {
+    n := len(keys)
+    redDepth := -1
+    if n > 1 {
+        redDepth = 0
+        for m := n; m > 1; m = m >> 1 {
+            redDepth++
+        }
+        if n&(n+1) == 0 {
+            // a perfect tree, all black
+            redDepth = -1
+        }
+    }
+    t.root = t.buildBalanced(keys, payloads, 0, n-1, 0, redDepth, t.leaf())
+    t.refreshExtremes()
+}
+
+// checkBulk checks entries about to be bulk-loaded into t like Put checks
+// each entry, so that no bulk load stores what Put would reject.
+func (t *Tree) checkBulk(keys, payloads []interface{}) error {
+    var kind reflect.Kind
+    if !isNil(t.root) {
+        kind = reflect.ValueOf(t.root.key).Kind()
+    }
+    for i, key := range keys {
+        if err := mustBeValidKey(key); err != nil {
+            return err
+        }
+        if payloads[i] == nil && t.rejectNil {
+            return ErrorNilValue
+        }
+        if t.sameKind {
+            if k := reflect.ValueOf(key).Kind(); kind == reflect.Invalid {
+                kind = k
+            } else if k != kind {
+                return ErrorKeyKindMismatch
+            }
+        }
+    }
+    return nil
+}
+
+// loadSorted bulk-loads the empty tree like buildSorted with entries which
+// passed checkBulk, & accounts for them as for as many Puts: they are
+// stamped (see WithInsertionSeq & WithAccessTracking) in key order &
+// counted as inserts.
+func (t *Tree) loadSorted(keys, payloads []interface{}) {
+    t.buildSorted(keys, payloads)
+    if t.seqOn || t.trackAccess {
+        inorder(t.root, func(n *Node) bool {
+            t.stamp(n)
+            t.touch(n)
+            return true
+        })
+    }
+    if t.counters != nil {
+        t.counters.Inserts += uint64(len(keys))
+    }
+}
+
+func (t *Tree) buildBalanced(keys, payloads []interface{}, lo, hi, depth, redDepth int, parent *Node) *Node {
+    if lo > hi {
+        return t.leaf()
+    }
+    mid := lo + (hi-lo)/2
+    color := BLACK
+    if depth == redDepth {
+        color = RED
+    }
+    node := t.newNode(keys[mid], payloads[mid], color, parent)
+    node.left = t.buildBalanced(keys, payloads, lo, mid-1, depth+1, redDepth, node)
+    node.right = t.buildBalanced(keys, payloads, mid+1, hi, depth+1, redDepth, node)
+    return node
+}
+
+// Filter returns a new tree holding only the entries for which `keep`
+// returns true. The original tree is left unchanged. Since the entries
+// are gathered in ascending order, the new tree is bulk-loaded balanced.
+func (t *Tree) Filter(keep func(key, value interface{}) bool) *Tree {
+    var keys, payloads []interface{}
+    inorder(t.root, func(n *Node) bool {
+        if keep(n.key, n.payload) {
+            keys = append(keys, n.key)
+            payloads = append(payloads, n.payload)
+        }
+        return true
+    })
+    result := t.emptyCopy()
+    result.buildSorted(keys, payloads)
+    return result
+}
+
+// Map returns a new tree with the same keys as t, each mapped to
+// `transform(key, value)`. The original tree is left unchanged; use
+// ReplaceAll to transform the payloads in place instead. The new tree is
+// configured like t, except that it does not reject nil payloads (see
+// RejectNilValue) when `transform` returns nil for any key.
+func (t *Tree) Map(transform func(key, value interface{}) interface{}) *Tree {
+    var keys, payloads []interface{}
+    hasNil := false
+    inorder(t.root, func(n *Node) bool {
+        payload := transform(n.key, n.payload)
+        hasNil = hasNil || payload == nil
+        keys = append(keys, n.key)
+        payloads = append(payloads, payload)
+        return true
+    })
+    result := t.emptyCopy()
+    if hasNil {
+        result.rejectNil = false
+    }
+    result.buildSorted(keys, payloads)
+    return result
+}
+
+// NewTreeFromSlices returns a tree mapping keys[i] to values[i]. It fails
+// with ErrorLengthMismatch when the slices differ in length. When the keys
+// are strictly ascending, the tree is bulk-loaded balanced in O(n);
+// otherwise each pair is inserted in turn, later duplicates overwriting
+// earlier ones.
+func NewTreeFromSlices(cmp Comparator, keys, values []interface{}) (*Tree, error) {
+    if len(keys) != len(values) {
+        return nil, ErrorLengthMismatch
+    }
+    for _, key := range keys {
+        if err := mustBeValidKey(key); err != nil {
+            return nil, err
+        }
+    }
+
+    t := NewTreeWith(cmp)
+    if isStrictlyAscending(cmp, keys) {
+        t.buildSorted(keys, values)
+        return t, nil
+    }
+    for i, key := range keys {
+        if err := t.Put(key, values[i]); err != nil {
+            return nil, err
+        }
+    }
+    return t, nil
+}
+
+// DuplicatePolicy decides which of several equal keys given to
+// NewTreeFromSorted ends up in the tree.
+type DuplicatePolicy int
+
+const (
+    // KeepLast keeps the value of the last of the equal keys, as if they
+    // were put in turn. It is the default.
+    KeepLast DuplicatePolicy = iota
+    // KeepFirst keeps the value of the first of the equal keys.
+    KeepFirst
+    // RejectDuplicates fails with ErrorDuplicateKey.
+    RejectDuplicates
+)
+
+// NewTreeFromSorted bulk-loads, balanced in O(n), a tree mapping keys[i] to
+// values[i]. The keys must be in ascending order according to `cmp`, else
+// it fails with ErrorKeysOutOfOrder; runs of equal keys are collapsed into
+// a single entry according to `policy`. It fails with ErrorLengthMismatch
+// when the slices differ in length. The slices are not modified.
+func NewTreeFromSorted(cmp Comparator, keys, values []interface{}, policy DuplicatePolicy) (*Tree, error) {
+    if len(keys) != len(values) {
+        return nil, ErrorLengthMismatch
+    }
+    for _, key := range keys {
+        if err := mustBeValidKey(key); err != nil {
+            return nil, err
+        }
+    }
+
+    uniqueKeys := make([]interface{}, 0, len(keys))
+    uniqueValues := make([]interface{}, 0, len(values))
+    for i, key := range keys {
+        if i > 0 {
+            switch c := cmp(keys[i-1], key); {
+            case c > 0:
+                return nil, ErrorKeysOutOfOrder
+            case c == 0:
+                switch policy {
+                case KeepLast:
+                    uniqueKeys[len(uniqueKeys)-1] = key
+                    uniqueValues[len(uniqueValues)-1] = values[i]
+                case RejectDuplicates:
+                    return nil, ErrorDuplicateKey
+                }
+                continue
+            }
+        }
+        uniqueKeys = append(uniqueKeys, key)
+        uniqueValues = append(uniqueValues, values[i])
+    }
+
+    t := NewTreeWith(cmp)
+    t.buildSorted(uniqueKeys, uniqueValues)
+    return t, nil
+}
+
+// NewViewFromSorted bulk-loads, balanced in O(n), a tree holding
+// `entries`, whose keys must be strictly ascending according to `cmp`. It
+// fails with ErrorKeysOutOfOrder or ErrorDuplicateKey otherwise. The
+// nodes only reference the keys & values of `entries`, which are neither
+// copied nor modified, but the tree does not track the slice afterwards:
+// writes go to the tree alone.
+func NewViewFromSorted(cmp Comparator, entries []Entry) (*Tree, error) {
+    keys := make([]interface{}, len(entries))
+    values := make([]interface{}, len(entries))
+    for i, e := range entries {
+        if err := mustBeValidKey(e.Key); err != nil {
+            return nil, err
+        }
+        if i > 0 {
+            switch c := cmp(entries[i-1].Key, e.Key); {
+            case c > 0:
+                return nil, ErrorKeysOutOfOrder
+            case c == 0:
+                return nil, ErrorDuplicateKey
+            }
+        }
+        keys[i], values[i] = e.Key, e.Value
+    }
+
+    t := NewTreeWith(cmp)
+    t.buildSorted(keys, values)
+    return t, nil
+}
+
+func isStrictlyAscending(cmp Comparator, keys []interface{}) bool {
+    for i := 1; i < len(keys); i++ {
+        if cmp(keys[i-1], keys[i]) >= 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// Partition splits the entries, in ascending key order, into `n`
+// contiguous chunks whose sizes differ by at most one, the larger chunks
+// coming first. Chunks are empty when there are fewer entries than `n`.
+// Each chunk covers a disjoint key range, so chunks can be handed to
+// separate goroutines. Partition returns nil when n < 1.
+func (t *Tree) Partition(n int) [][]Entry {
+    if n < 1 {
+        return nil
+    }
+    entries := t.entries()
+    chunks := make([][]Entry, n)
+    size, extra := len(entries)/n, len(entries)%n
+    start := 0
+    for i := range chunks {
+        end := start + size
+        if i < extra {
+            end++
+        }
+        chunks[i] = entries[start:end:end]
+        start = end
+    }
+    return chunks
+}
+
+// DetachSubtree removes the subtree rooted at the node of `key` & returns
+// its entries as a standalone tree configured like t, bulk-loaded balanced.
+// The remaining tree is rebalanced like after as many Deletes. It returns
+// false, leaving t unchanged, when `key` is absent.
+func (t *Tree) DetachSubtree(key interface{}) (*Tree, bool) {
+    found, n := t.getNode(key)
+    if !found {
+        return nil, false
+    }
+    var keys, payloads []interface{}
+    inorder(n, func(n *Node) bool {
+        keys = append(keys, n.key)
+        payloads = append(payloads, n.payload)
+        return true
+    })
+    for _, key := range keys {
+        t.Delete(key)
+    }
+    detached := t.emptyCopy()
+    detached.buildSorted(keys, payloads)
+    return detached, true
+}
+
+// AttachSubtree puts every entry of `other`, e.g. a tree obtained from
+// DetachSubtree, into t. The keys of the two trees must be disjoint: when
+// a key of `other` is already in t, it fails with ErrorDuplicateKey. Every
+// entry is checked as by Put before attaching anything, so on failure t is
+// unchanged. `other` is unchanged. An empty t is bulk-loaded balanced,
+// unless the comparators disagree on the order of the keys.
+func (t *Tree) AttachSubtree(other *Tree) error {
+    var keys, payloads []interface{}
+    inorder(other.root, func(n *Node) bool {
+        keys = append(keys, n.key)
+        payloads = append(payloads, n.payload)
+        return true
+    })
+    if len(keys) == 0 {
+        return nil
+    }
+    cmp := t.cmp
+    if err := t.checkBulk(keys, payloads); err != nil {
+        logger.Printf("AttachSubtree was prematurely aborted: %s\n", err.Error())
+        return err
+    }
+    if cmp == nil && t.auto {
+        var err error
+        if cmp, err = t.autoComparator(keys[0]); err != nil {
+            logger.Printf("AttachSubtree was prematurely aborted: %s\n", err.Error())
+            return err
+        }
+    }
+    if !isNil(t.root) {
+        for _, key := range keys {
+            if t.Has(key) {
+                logger.Printf("AttachSubtree was prematurely aborted: %s\n", ErrorDuplicateKey.Error())
+                return ErrorDuplicateKey
+            }
+        }
+    }
+
+    t.cmp = cmp
+    if isNil(t.root) && isStrictlyAscending(t.cmp, keys) {
+        t.loadSorted(keys, payloads)
+        return nil
+    }
+    for i, key := range keys {
+        if err := t.Put(key, payloads[i]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Clone returns a copy of the tree, configured like t, with the same
+// shape, colors, keys & payloads. Keys & payloads themselves are shared,
+// not copied. Later changes to either tree do not affect the other.
+func (t *Tree) Clone() *Tree {
+    c := t.emptyCopy()
+    var clone func(n, parent *Node) *Node
+    clone = func(n, parent *Node) *Node {
+        if isNil(n) {
+            return c.leaf()
+        }
+        copied := c.newNode(n.key, n.payload, n.color, parent)
+        if n.meta != nil {
+            meta := *n.meta
+            copied.meta = &meta
+        }
+        copied.left = clone(n.left, copied)
+        copied.right = clone(n.right, copied)
+        return copied
+    }
+    c.root = clone(t.root, c.leaf())
+    c.refreshExtremes()
+    return c
+}
+
+// mergeCursor is the position of MergeTrees in one of its input trees.
+type mergeCursor struct {
+    node  *Node
+    input int // index of the tree among the inputs
+}
+
+// mergeHeap orders cursors by key, then by input.
+type mergeHeap struct {
+    cursors []mergeCursor
+    cmp     Comparator
+}
+
+func (h *mergeHeap) Len() int {
+    return len(h.cursors)
+}
+
+func (h *mergeHeap) Less(i, j int) bool {
+    if c := h.cmp(h.cursors[i].node.key, h.cursors[j].node.key); c != 0 {
+        return c < 0
+    }
+    return h.cursors[i].input < h.cursors[j].input
+}
+
+func (h *mergeHeap) Swap(i, j int) {
+    h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+    h.cursors = append(h.cursors, x.(mergeCursor))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+    last := h.cursors[len(h.cursors)-1]
+    h.cursors = h.cursors[:len(h.cursors)-1]
+    return last
+}
+
+// mergeSampleSize is the number of keys taken from either end of each
+// input tree to check that its comparator agrees with that of MergeTrees.
+const mergeSampleSize = 8
+
+// MergeTrees merges the entries of `trees` into a new tree ordered by
+// `cmp`, bulk-loaded balanced. The inputs are merged k ways as they are
+// walked in order, in O(n log k) for n entries over k trees. When several
+// trees hold a key, the last of them in `trees` wins. The inputs are left
+// unchanged; nil trees are skipped.
+//
+// The comparator of each tree must order its keys like `cmp`, as checked
+// by ComparatorsAgree on the smallest & largest keys of the tree; it fails
+// with ErrorComparatorMismatch otherwise.
+func MergeTrees(cmp Comparator, trees ...*Tree) (*Tree, error) {
+    h := &mergeHeap{cmp: cmp}
+    for i, t := range trees {
+        if t == nil || isNil(t.root) {
+            continue
+        }
+        samples := entryKeys(append(t.Head(mergeSampleSize), t.Tail(mergeSampleSize)...))
+        if !ComparatorsAgree(cmp, t.cmp, samples) {
+            return nil, ErrorComparatorMismatch
+        }
+        h.cursors = append(h.cursors, mergeCursor{node: t.minimum(), input: i})
+    }
+    heap.Init(h)
+
+    var keys, payloads []interface{}
+    for h.Len() > 0 {
+        cursor := heap.Pop(h).(mergeCursor)
+        last := len(keys) - 1
+        if last >= 0 && cmp(keys[last], cursor.node.key) == 0 {
+            // equal keys pop in input order, so the later input wins
+            keys[last], payloads[last] = cursor.node.key, cursor.node.payload
+        } else {
+            keys = append(keys, cursor.node.key)
+            payloads = append(payloads, cursor.node.payload)
+        }
+        if next := trees[cursor.input].successor(cursor.node); next != nil {
+            heap.Push(h, mergeCursor{node: next, input: cursor.input})
+        }
+    }
+
+    t := NewTreeWith(cmp)
+    t.buildSorted(keys, payloads)
+    return t, nil
+}
+
+// entryKeys returns the keys of `entries`, in order.
+func entryKeys(entries []Entry) []interface{} {
+    keys := make([]interface{}, len(entries))
+    for i, entry := range entries {
+        keys[i] = entry.Key
+    }
+    return keys
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import "math"
+
+// compactMaxIndex is the largest index a compactNode can link to.
+var compactMaxIndex = math.MaxInt32
+
+// compactNode is the Node of a CompactIntTree. Links are indices into
+// the slice of nodes of the tree, 0 standing for the sentinel leaf.
+type compactNode struct {
+    key     int
+    payload interface{}
+    left    int32
+    right   int32
+    parent  int32
+    color   Color
+}
+
+// CompactIntTree is a red-black tree of `int` keys whose nodes live in a
+// single slice & link to each other by 32-bit indices instead of pointers.
+// Nodes are smaller & contiguous, which helps the cache when keys are many
+// & small, e.g. dense ids; keys are compared directly, without a
+// Comparator. The slots of deleted nodes are reused by later Puts.
+// It is not a drop-in replacement for Tree: keys are `int` rather than
+// interface{}, & only Get, Has, Size, Put, Delete, Min, Max, Each & Keys
+// are offered; use Tree for anything else. The 32-bit indices limit it to
+// math.MaxInt32 nodes, beyond which Put fails with ErrorTreeFull.
+type CompactIntTree struct {
+    nodes []compactNode // nodes[0] is the sentinel
+    root  int32
+    free  []int32 // slots of deleted nodes
+    size  uint64
+}
+
+// NewCompactIntTree returns an empty CompactIntTree with room for `hint`
+// nodes before its slice of nodes has to grow.
+func NewCompactIntTree(hint int) *CompactIntTree {
+    if hint < 0 {
+        hint = 0
+    }
+    if hint > compactMaxIndex {
+        hint = compactMaxIndex
+    }
+    nodes := make([]compactNode, 1, hint+1)
+    nodes[0].color = BLACK
+    return &CompactIntTree{nodes: nodes}
+}
+
+// find returns the index of the node of `key`, or 0 when it is absent.
+func (t *CompactIntTree) find(key int) int32 {
+    x := t.root
+    for x != 0 {
+        switch n := &t.nodes[x]; {
+        case key < n.key:
+            x = n.left
+        case key > n.key:
+            x = n.right
+        default:
+            return x
+        }
+    }
+    return 0
+}
+
+// Get looks for the node with supplied key and returns its mapped payload.
+// Return value in 1st position indicates whether any payload was found.
+func (t *CompactIntTree) Get(key int) (bool, interface{}) {
+    if x := t.find(key); x != 0 {
+        return true, t.nodes[x].payload
+    }
+    return false, nil
+}
+
+// Has checks for existence of a item identified by supplied key.
+func (t *CompactIntTree) Has(key int) bool {
+    return t.find(key) != 0
+}
+
+// Size returns the number of items in the tree.
+func (t *CompactIntTree) Size() uint64 {
+    return t.size
+}
+
+// Put saves the mapping (key, data) into the tree.
+// If a mapping identified by `key` already exists, it is overwritten.
+// Every int being a valid key, it only fails with ErrorTreeFull when a new
+// node would need an index past math.MaxInt32.
+func (t *CompactIntTree) Put(key int, data interface{}) error {
+    var parent int32
+    for x := t.root; x != 0; {
+        parent = x
+        switch n := &t.nodes[x]; {
+        case key < n.key:
+            x = n.left
+        case key > n.key:
+            x = n.right
+        default:
+            n.payload = data
+            return nil
+        }
+    }
+
+    if len(t.free) == 0 && len(t.nodes) > compactMaxIndex {
+        logger.Printf("CompactIntTree.Put was prematurely aborted: %s\n", ErrorTreeFull.Error())
+        return ErrorTreeFull
+    }
+    z := t.alloc(key, data, parent)
+    switch {
+    case parent == 0:
+        t.root = z
+    case key < t.nodes[parent].key:
+        t.nodes[parent].left = z
+    default:
+        t.nodes[parent].right = z
+    }
+    t.size++
+    t.fixupPut(z)
+    return nil
+}
+
+// alloc returns the index of a new red node, reusing a free slot if any.
+func (t *CompactIntTree) alloc(key int, data interface{}, parent int32) int32 {
+    n := compactNode{key: key, payload: data, parent: parent, color: RED}
+    if last := len(t.free) - 1; last >= 0 {
+        z := t.free[last]
+        t.free = t.free[:last]
+        t.nodes[z] = n
+        return z
+    }
+    t.nodes = append(t.nodes, n)
+    return int32(len(t.nodes) - 1)
+}
+
+func (t *CompactIntTree) rotateLeft(x int32) {
+    nodes := t.nodes
+    y := nodes[x].right
+    nodes[x].right = nodes[y].left
+    if nodes[y].left != 0 {
+        nodes[nodes[y].left].parent = x
+    }
+    nodes[y].parent = nodes[x].parent
+    switch p := nodes[x].parent; {
+    case p == 0:
+        t.root = y
+    case x == nodes[p].left:
+        nodes[p].left = y
+    default:
+        nodes[p].right = y
+    }
+    nodes[y].left = x
+    nodes[x].parent = y
+}
+
+func (t *CompactIntTree) rotateRight(y int32) {
+    nodes := t.nodes
+    x := nodes[y].left
+    nodes[y].left = nodes[x].right
+    if nodes[x].right != 0 {
+        nodes[nodes[x].right].parent = y
+    }
+    nodes[x].parent = nodes[y].parent
+    switch p := nodes[y].parent; {
+    case p == 0:
+        t.root = x
+    case y == nodes[p].left:
+        nodes[p].left = x
+    default:
+        nodes[p].right = x
+    }
+    nodes[x].right = y
+    nodes[y].parent = x
+}
+
+// fixupPut is Tree.fixupPut over indices.
+func (t *CompactIntTree) fixupPut(z int32) {
+    nodes := t.nodes
+    for nodes[nodes[z].parent].color == RED {
+        p := nodes[z].parent
+        g := nodes[p].parent
+        if p == nodes[g].left {
+            if y := nodes[g].right; nodes[y].color == RED {
+                nodes[p].color, nodes[y].color, nodes[g].color = BLACK, BLACK, RED
+                z = g
+                continue
+            }
+            if z == nodes[p].right {
+                z = p
+                t.rotateLeft(z)
+                p = nodes[z].parent
+            }
+            nodes[p].color, nodes[g].color = BLACK, RED
+            t.rotateRight(g)
+        } else {
+            if y := nodes[g].left; nodes[y].color == RED {
+                nodes[p].color, nodes[y].color, nodes[g].color = BLACK, BLACK, RED
+                z = g
+                continue
+            }
+            if z == nodes[p].left {
+                z = p
+                t.rotateRight(z)
+                p = nodes[z].parent
+            }
+            nodes[p].color, nodes[g].color = BLACK, RED
+            t.rotateLeft(g)
+        }
+    }
+    nodes[t.root].color = BLACK
+}
+
+// transplant is Tree.transplant over indices.
+func (t *CompactIntTree) transplant(u, v int32) {
+    nodes := t.nodes
+    switch p := nodes[u].parent; {
+    case p == 0:
+        t.root = v
+    case u == nodes[p].left:
+        nodes[p].left = v
+    default:
+        nodes[p].right = v
+    }
+    nodes[v].parent = nodes[u].parent
+}
+
+// Delete removes the item identified by the supplied key.
+// Delete is a noop if the supplied key doesn't exist.
+func (t *CompactIntTree) Delete(key int) {
+    z := t.find(key)
+    if z == 0 {
+        return
+    }
+    nodes := t.nodes
+    y, yOriginalColor := z, nodes[z].color
+    var x int32
+
+    switch {
+    case nodes[z].left == 0:
+        x = nodes[z].right
+        t.transplant(z, x)
+    case nodes[z].right == 0:
+        x = nodes[z].left
+        t.transplant(z, x)
+    default:
+        y = nodes[z].right
+        for nodes[y].left != 0 {
+            y = nodes[y].left
+        }
+        yOriginalColor = nodes[y].color
+        x = nodes[y].right
+        if nodes[y].parent == z {
+            nodes[x].parent = y
+        } else {
+            t.transplant(y, x)
+            nodes[y].right = nodes[z].right
+            nodes[nodes[y].right].parent = y
+        }
+        t.transplant(z, y)
+        nodes[y].left = nodes[z].left
+        nodes[nodes[y].left].parent = y
+        nodes[y].color = nodes[z].color
+    }
+    if yOriginalColor == BLACK {
+        t.fixupDelete(x)
+    }
+
+    nodes[z] = compactNode{}
+    nodes[0].parent = 0
+    t.free = append(t.free, z)
+    t.size--
+}
+
+// fixupDelete is Tree.fixupDelete over indices.
+func (t *CompactIntTree) fixupDelete(x int32) {
+    nodes := t.nodes
+    for x != t.root && nodes[x].color == BLACK {
+        p := nodes[x].parent
+        if x == nodes[p].left {
+            w := nodes[p].right
+            if nodes[w].color == RED {
+                nodes[w].color, nodes[p].color = BLACK, RED
+                t.rotateLeft(p)
+                w = nodes[p].right
+            }
+            if nodes[nodes[w].left].color == BLACK && nodes[nodes[w].right].color == BLACK {
+                nodes[w].color = RED
+                x = p
+                continue
+            }
+            if nodes[nodes[w].right].color == BLACK {
+                nodes[nodes[w].left].color, nodes[w].color = BLACK, RED
+                t.rotateRight(w)
+                w = nodes[p].right
+            }
+            nodes[w].color, nodes[p].color = nodes[p].color, BLACK
+            nodes[nodes[w].right].color = BLACK
+            t.rotateLeft(p)
+            x = t.root
+        } else {
+            w := nodes[p].left
+            if nodes[w].color == RED {
+                nodes[w].color, nodes[p].color = BLACK, RED
+                t.rotateRight(p)
+                w = nodes[p].left
+            }
+            if nodes[nodes[w].right].color == BLACK && nodes[nodes[w].left].color == BLACK {
+                nodes[w].color = RED
+                x = p
+                continue
+            }
+            if nodes[nodes[w].left].color == BLACK {
+                nodes[nodes[w].right].color, nodes[w].color = BLACK, RED
+                t.rotateLeft(w)
+                w = nodes[p].left
+            }
+            nodes[w].color, nodes[p].color = nodes[p].color, BLACK
+            nodes[nodes[w].left].color = BLACK
+            t.rotateRight(p)
+            x = t.root
+        }
+    }
+    nodes[x].color = BLACK
+}
+
+// Min returns the entry with the smallest key.
+// The boolean is false when the tree is empty.
+func (t *CompactIntTree) Min() (*Entry, bool) {
+    if t.root == 0 {
+        return nil, false
+    }
+    x := t.root
+    for t.nodes[x].left != 0 {
+        x = t.nodes[x].left
+    }
+    return &Entry{Key: t.nodes[x].key, Value: t.nodes[x].payload}, true
+}
+
+// Max returns the entry with the largest key.
+// The boolean is false when the tree is empty.
+func (t *CompactIntTree) Max() (*Entry, bool) {
+    if t.root == 0 {
+        return nil, false
+    }
+    x := t.root
+    for t.nodes[x].right != 0 {
+        x = t.nodes[x].right
+    }
+    return &Entry{Key: t.nodes[x].key, Value: t.nodes[x].payload}, true
+}
+
+// Each calls `fn` on every entry in ascending key order,
+// stopping early when `fn` returns false.
+func (t *CompactIntTree) Each(fn func(key int, value interface{}) bool) {
+    var each func(x int32) bool
+    each = func(x int32) bool {
+        if x == 0 {
+            return true
+        }
+        n := &t.nodes[x]
+        return each(n.left) && fn(n.key, n.payload) && each(n.right)
+    }
+    each(t.root)
+}
+
+// Keys returns all keys in ascending order.
+func (t *CompactIntTree) Keys() []int {
+    keys := make([]int, 0, t.size)
+    t.Each(func(key int, value interface{}) bool {
+        keys = append(keys, key)
+        return true
+    })
+    return keys
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import (
+    "reflect"
+)
+
+// equalValues compares with ==, treating values of
+// types which are not comparable as unequal.
+func equalValues(a, b interface{}) bool {
+    if a == nil || b == nil {
+        return a == nil && b == nil
+    }
+    if !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+        return false
+    }
+    return a == b
+}
+
+// entries returns the entries of the tree in ascending key order.
+func (t *Tree) entries() []Entry {
+    entries := []Entry{}
+    inorder(t.root, func(n *Node) bool {
+        entries = append(entries, Entry{Key: n.key, Value: n.payload})
+        return true
+    })
+    return entries
+}
+
+// SameContent reports whether both trees hold the same keys mapped to
+// the same payloads, whatever their shapes. Keys & payloads are compared
+// with ==; payloads of a type which is not comparable (e.g. slices) are
+// never equal.
+func (t *Tree) SameContent(other *Tree) bool {
+    if other == nil {
+        return false
+    }
+    mine, theirs := t.entries(), other.entries()
+    if len(mine) != len(theirs) {
+        return false
+    }
+    for i := range mine {
+        if !equalValues(mine[i].Key, theirs[i].Key) || !equalValues(mine[i].Value, theirs[i].Value) {
+            return false
+        }
+    }
+    return true
+}
+
+// ToMap returns the entries of the tree as a Go map. It fails with
+// ErrorKeyUnhashable, returning no map, when a key cannot be a map key,
+// e.g. a struct holding a slice.
+//
+// A tree may hold several entries with keys equal as map keys, e.g. as a
+// multimap built by NewTreeWith2 with a tiebreak that never returns 0. Only
+// the last of those in key order ends up in the map, which is the last
+// inserted when the tiebreak orders new keys after equal ones.
+func (t *Tree) ToMap() (map[interface{}]interface{}, error) {
+    var err error
+    inorder(t.root, func(n *Node) bool {
+        if !hashable(reflect.ValueOf(n.key)) {
+            err = ErrorKeyUnhashable
+        }
+        return err == nil
+    })
+    if err != nil {
+        logger.Printf("ToMap was prematurely aborted: %s\n", err.Error())
+        return nil, err
+    }
+    m := make(map[interface{}]interface{})
+    inorder(t.root, func(n *Node) bool {
+        m[n.key] = n.payload
+        return true
+    })
+    return m, nil
+}
+
+// hashable reports whether v can be used as a map key without panicking,
+// looking into the dynamic values of interface fields too.
+func hashable(v reflect.Value) bool {
+    if !v.IsValid() {
+        return true
+    }
+    switch v.Kind() {
+    case reflect.Interface:
+        return v.IsNil() || hashable(v.Elem())
+    case reflect.Struct:
+        for i := 0; i < v.NumField(); i++ {
+            if !hashable(v.Field(i)) {
+                return false
+            }
+        }
+        return true
+    case reflect.Array:
+        for i := 0; i < v.Len(); i++ {
+            if !hashable(v.Index(i)) {
+                return false
+            }
+        }
+        return true
+    default:
+        return v.Type().Comparable()
+    }
+}
+
+// Diff compares two snapshots of a tree, walking both in order at once in
+// O(n+m). Keys only in `to` are added, keys only in `from` are removed, &
+// keys in both whose payloads fail `valueEq` are changed, reported with
+// the payload of `to`. With a nil `valueEq` no key is ever changed. Keys
+// are matched with the comparator of `from`; a nil tree counts as empty.
+func Diff(from, to *Tree, valueEq func(a, b interface{}) bool) (added, removed, changed []Entry) {
+    var before, after []Entry
+    var cmp Comparator
+    if from != nil {
+        before, cmp = from.entries(), from.cmp
+    }
+    if to != nil {
+        after = to.entries()
+    }
+
+    i, j := 0, 0
+    for i < len(before) && j < len(after) {
+        switch c := cmp(before[i].Key, after[j].Key); {
+        case c < 0:
+            removed = append(removed, before[i])
+            i++
+        case c > 0:
+            added = append(added, after[j])
+            j++
+        default:
+            if valueEq != nil && !valueEq(before[i].Value, after[j].Value) {
+                changed = append(changed, after[j])
+            }
+            i++
+            j++
+        }
+    }
+    removed = append(removed, before[i:]...)
+    added = append(added, after[j:]...)
+    return added, removed, changed
+}
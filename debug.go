@@ -0,0 +1,546 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import (
+    "bytes"
+    "fmt"
+    "math"
+)
+
+func sign(i int) int {
+    switch {
+    case i > 0:
+        return 1
+    case i < 0:
+        return -1
+    default:
+        return 0
+    }
+}
+
+// mustBeConsistent checks the comparator of the tree against `key` and
+// the stored keys along the search path of `key`. It panics on the first
+// violation found:
+// (i) cmp(key, key) must be zero
+// (ii) cmp(key, k) must be the negation of cmp(k, key)
+// (iii) key < child < parent implies key < parent (and likewise for >)
+func (t *Tree) mustBeConsistent(key interface{}) {
+    if c := t.cmp(key, key); c != 0 {
+        t.comparatorViolation(fmt.Sprintf("cmp(%#v, %#v) = %d, expected 0", key, key, c))
+    }
+
+    var parent *Node
+    for n := t.root; !isNil(n); {
+        c := sign(t.cmp(key, n.key))
+        if r := sign(t.cmp(n.key, key)); c != -r {
+            t.comparatorViolation(fmt.Sprintf("cmp(%#v, %#v) = %d but cmp(%#v, %#v) = %d", key, n.key, c, n.key, key, r))
+        }
+        if parent != nil && c != 0 {
+            // n sits below parent, so the stored keys are ordered as
+            // sign(cmp(n, parent)); key must follow the same direction
+            order := sign(t.cmp(n.key, parent.key))
+            if c == order && sign(t.cmp(key, parent.key)) != order {
+                t.comparatorViolation(fmt.Sprintf("cmp(%#v, %#v) = %d & cmp(%#v, %#v) = %d but cmp(%#v, %#v) = %d",
+                    key, n.key, c, n.key, parent.key, order, key, parent.key, sign(t.cmp(key, parent.key))))
+            }
+        }
+        switch {
+        case c < 0:
+            parent, n = n, n.left
+        case c > 0:
+            parent, n = n, n.right
+        default:
+            return
+        }
+    }
+}
+
+func (t *Tree) comparatorViolation(msg string) {
+    logger.Printf("Comparator check failed: %s\n", msg)
+    panic("redblacktree: inconsistent comparator: " + msg)
+}
+
+// Diagnose inspects the whole tree & returns every violation of the
+// red-black invariants it finds, each naming the offending node by key:
+// a red root, a red node with a red child, differing black heights of
+// the two subtrees of a node & parent pointers that do not match the
+// actual parent. An empty result means the tree is healthy. The tree
+// is not modified.
+func (t *Tree) Diagnose() []string {
+    problems := []string{}
+    if isNil(t.root) {
+        return problems
+    }
+    if t.root.color == RED {
+        problems = append(problems, fmt.Sprintf("root %#v is Red", t.root.key))
+    }
+    if !isNil(t.root.parent) {
+        problems = append(problems, fmt.Sprintf("root %#v has parent %#v", t.root.key, t.root.parent.key))
+    }
+    t.diagnose(t.root, &problems)
+    return problems
+}
+
+// diagnose returns the black height of the subtree rooted at n,
+// counting the leaves. Where the two subtrees disagree, the left
+// one is reported upwards.
+func (t *Tree) diagnose(n *Node, problems *[]string) int {
+    if isNil(n) {
+        return 1
+    }
+    for _, child := range []*Node{n.left, n.right} {
+        if isNil(child) {
+            continue
+        }
+        if child.parent != n {
+            parent := "nil"
+            if !isNil(child.parent) {
+                parent = fmt.Sprintf("%#v", child.parent.key)
+            }
+            *problems = append(*problems, fmt.Sprintf("node %#v has parent %s instead of %#v", child.key, parent, n.key))
+        }
+        if n.color == RED && child.color == RED {
+            *problems = append(*problems, fmt.Sprintf("Red node %#v has Red child %#v", n.key, child.key))
+        }
+    }
+    left, right := t.diagnose(n.left, problems), t.diagnose(n.right, problems)
+    if left != right {
+        *problems = append(*problems, fmt.Sprintf("node %#v has black height %d on the left but %d on the right", n.key, left, right))
+    }
+    if n.color == BLACK {
+        return left + 1
+    }
+    return left
+}
+
+// PathColors returns the colors of the nodes met while searching for
+// `key`, from the root down to the node holding `key` inclusive.
+// It returns false when `key` is absent.
+func (t *Tree) PathColors(key interface{}) ([]Color, bool) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("PathColors was prematurely aborted: %s\n", err.Error())
+        return nil, false
+    }
+
+    colors := []Color{}
+    for n := t.root; !isNil(n); {
+        colors = append(colors, n.color)
+        switch c := t.cmp(key, n.key); {
+        case c < 0:
+            n = n.left
+        case c > 0:
+            n = n.right
+        default:
+            return colors, true
+        }
+    }
+    return nil, false
+}
+
+// withParents fills in the parent links of a tree built from nested
+// literals, as emitted by GoLiteral, & returns the tree.
+func withParents(t *Tree) *Tree {
+    var link func(n *Node)
+    link = func(n *Node) {
+        for _, child := range []*Node{n.left, n.right} {
+            if !isNil(child) {
+                child.parent = n
+                link(child)
+            }
+        }
+    }
+    if !isNil(t.root) {
+        t.root.parent = nil
+        link(t.root)
+    }
+    return t
+}
+
+// comparatorLiteral returns Go source for the comparator of the tree,
+// looked up by the name the tree was created with, or nil.
+func (t *Tree) comparatorLiteral() string {
+    if name, ok := t.ComparatorName(); ok {
+        return fmt.Sprintf("registered(%q), cmpName: %q", name, name)
+    }
+    return "nil"
+}
+
+// GoLiteral returns Go source, for use inside this package (e.g. in a
+// test), which rebuilds the tree with the exact same shape, keys, payloads
+// & colors: nested `Node` literals, the way the trees of the tests are
+// built by hand, passed to `withParents` to restore the parent links.
+// Keys & payloads are printed with %#v, so the output compiles for `int`
+// & `string` keys & payloads. The comparator is looked up by the name the
+// tree was created with (see NewTreeNamed), e.g. `registered("int")`; an
+// unnamed comparator is emitted as nil & must be filled in.
+func (t *Tree) GoLiteral() string {
+    var buf bytes.Buffer
+    buf.WriteString("withParents(&Tree{cmp: " + t.comparatorLiteral())
+    if !isNil(t.root) {
+        buf.WriteString(", root: ")
+        goLiteral(&buf, t.root, "")
+    }
+    buf.WriteString("})")
+    return buf.String()
+}
+
+func goLiteral(buf *bytes.Buffer, n *Node, indent string) {
+    buf.WriteString(fmt.Sprintf("&Node{key: %#v, ", n.key))
+    if n.payload != nil {
+        buf.WriteString(fmt.Sprintf("payload: %#v, ", n.payload))
+    }
+    if n.color == BLACK {
+        buf.WriteString("color: BLACK")
+    } else {
+        buf.WriteString("color: RED")
+    }
+    if !isNil(n.left) {
+        buf.WriteString(",\n" + indent + "    left: ")
+        goLiteral(buf, n.left, indent+"    ")
+    }
+    if !isNil(n.right) {
+        buf.WriteString(",\n" + indent + "    right: ")
+        goLiteral(buf, n.right, indent+"    ")
+    }
+    buf.WriteString("}")
+}
+
+// heightAndSize returns the number of nodes on the longest path from n
+// down to a leaf, & the number of nodes, of the subtree rooted at n.
+func heightAndSize(n *Node) (height int, size int) {
+    if isNil(n) {
+        return 0, 0
+    }
+    lh, ls := heightAndSize(n.left)
+    rh, rs := heightAndSize(n.right)
+    if rh > lh {
+        lh = rh
+    }
+    return lh + 1, ls + rs + 1
+}
+
+// Balance returns the height of the tree divided by the height of a
+// perfectly balanced tree of the same size, ⌈log2(n+1)⌉. A value of 1.0
+// means perfectly balanced. The red-black properties bound the height by
+// 2·log2(n+1), so a healthy tree stays at or below about 2.0; an empty
+// tree reports 1.0.
+func (t *Tree) Balance() float64 {
+    height, size := heightAndSize(t.root)
+    if size == 0 {
+        return 1.0
+    }
+    ideal := 0
+    for m := size + 1; m > 1; m = (m + 1) / 2 {
+        ideal++
+    }
+    return float64(height) / float64(ideal)
+}
+
+// WithinHeightBound reports whether the height of the tree is at most
+// 2·log2(n+1), the bound every red-black tree of n nodes satisfies. A
+// false result means the balancing is broken. It takes a single pass &
+// is cheaper than Diagnose, but also catches less.
+func (t *Tree) WithinHeightBound() bool {
+    height, size := heightAndSize(t.root)
+    return float64(height) <= 2*math.Log2(float64(size+1))
+}
+
+// blackHeights is the closed interval of black heights, leaves included,
+// that a subtree can be given by recoloring alone. It is empty when lo > hi.
+type blackHeights struct {
+    lo, hi int
+}
+
+func (h blackHeights) has(bh int) bool {
+    return h.lo <= bh && bh <= h.hi
+}
+
+func (h blackHeights) intersect(o blackHeights) blackHeights {
+    if o.lo > h.lo {
+        h.lo = o.lo
+    }
+    if o.hi < h.hi {
+        h.hi = o.hi
+    }
+    return h
+}
+
+// union assumes that the two intervals overlap or touch when both are
+// non-empty, which holds for the two colorings of the same node.
+func (h blackHeights) union(o blackHeights) blackHeights {
+    switch {
+    case h.lo > h.hi:
+        return o
+    case o.lo > o.hi:
+        return h
+    }
+    if o.lo < h.lo {
+        h.lo = o.lo
+    }
+    if o.hi > h.hi {
+        h.hi = o.hi
+    }
+    return h
+}
+
+// RepairColors restores the red-black properties of a tree whose colors
+// or parent links were corrupted, e.g. by manual construction or by a
+// faulty delete. Parent links are recomputed first. If the shape of the
+// tree admits a valid coloring, the nodes are recolored in place; otherwise
+// the tree is rebuilt balanced from its entries. Either way Diagnose
+// reports no problem afterwards. A healthy tree is left untouched.
+//
+// It returns ErrorKeysOutOfOrder, without modifying the tree, when the
+// keys are not in ascending order: no coloring can repair that.
+func (t *Tree) RepairColors() error {
+    var keys, payloads []interface{}
+    inorder(t.root, func(n *Node) bool {
+        keys = append(keys, n.key)
+        payloads = append(payloads, n.payload)
+        return true
+    })
+    if !isStrictlyAscending(t.cmp, keys) {
+        logger.Printf("RepairColors was prematurely aborted: %s\n", ErrorKeysOutOfOrder.Error())
+        return ErrorKeysOutOfOrder
+    }
+    if isNil(t.root) {
+        t.root = t.leaf()
+        return nil
+    }
+
+    t.root.parent = t.leaf()
+    t.relink(t.root)
+    if len(t.Diagnose()) == 0 {
+        return nil
+    }
+
+    asBlack, asRed := map[*Node]blackHeights{}, map[*Node]blackHeights{}
+    t.colorings(t.root, asBlack, asRed)
+    if bh := asBlack[t.root]; bh.lo <= bh.hi {
+        logger.Printf("RepairColors: recoloring with black height %d\n", bh.lo)
+        t.recolor(t.root, BLACK, bh.lo, asBlack)
+        return nil
+    }
+    logger.Printf("RepairColors: no valid coloring, rebuilding %d nodes\n", len(keys))
+    t.buildSorted(keys, payloads)
+    return nil
+}
+
+// relink points the children of every node below n, leaves included,
+// back at their parent.
+func (t *Tree) relink(n *Node) {
+    n.left, n.right = t.leafIfNil(n.left), t.leafIfNil(n.right)
+    for _, child := range []*Node{n.left, n.right} {
+        if !isNil(child) {
+            child.parent = n
+            t.relink(child)
+        }
+    }
+}
+
+// colorings fills in, for every node of the subtree rooted at n, the
+// black heights reachable with the node colored black & colored red.
+func (t *Tree) colorings(n *Node, asBlack, asRed map[*Node]blackHeights) (black, red blackHeights) {
+    if isNil(n) {
+        return blackHeights{1, 1}, blackHeights{1, 0}
+    }
+    leftBlack, leftRed := t.colorings(n.left, asBlack, asRed)
+    rightBlack, rightRed := t.colorings(n.right, asBlack, asRed)
+
+    black = leftBlack.union(leftRed).intersect(rightBlack.union(rightRed))
+    black.lo, black.hi = black.lo+1, black.hi+1
+    red = leftBlack.intersect(rightBlack)
+    asBlack[n], asRed[n] = black, red
+    return black, red
+}
+
+// recolor gives n the color c & its subtree the black height bh, which
+// colorings found reachable.
+func (t *Tree) recolor(n *Node, c Color, bh int, asBlack map[*Node]blackHeights) {
+    n.color = c
+    if c == BLACK {
+        bh--
+    }
+    for _, child := range []*Node{n.left, n.right} {
+        if isNil(child) {
+            continue
+        }
+        if c == RED || asBlack[child].has(bh) {
+            t.recolor(child, BLACK, bh, asBlack)
+        } else {
+            t.recolor(child, RED, bh, asBlack)
+        }
+    }
+}
+
+// CheckConnectivity verifies the links of a tree, typically one built by
+// hand: the root must have no parent, every node reachable from the root
+// must point back at the node it hangs from & no node may be reached twice,
+// which rules out cycles & shared subtrees. It returns an error wrapping
+// ErrorBrokenLink that names the first inconsistency found by key, or nil.
+// The tree is not modified; RepairColors recomputes the parent links.
+func (t *Tree) CheckConnectivity() error {
+    if isNil(t.root) {
+        return nil
+    }
+    if !isNil(t.root.parent) {
+        return fmt.Errorf("%w: root %#v has parent %#v", ErrorBrokenLink, t.root.key, t.root.parent.key)
+    }
+    return t.checkLinks(t.root, map[*Node]bool{})
+}
+
+func (t *Tree) checkLinks(n *Node, seen map[*Node]bool) error {
+    if seen[n] {
+        return fmt.Errorf("%w: node %#v is reached twice", ErrorBrokenLink, n.key)
+    }
+    seen[n] = true
+    for _, child := range []*Node{n.left, n.right} {
+        if isNil(child) {
+            continue
+        }
+        if child.parent != n {
+            parent := "nil"
+            if !isNil(child.parent) {
+                parent = fmt.Sprintf("%#v", child.parent.key)
+            }
+            return fmt.Errorf("%w: node %#v has parent %s instead of %#v", ErrorBrokenLink, child.key, parent, n.key)
+        }
+        if err := t.checkLinks(child, seen); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Edge links the key of a parent node to the key of one of its children,
+// which hangs on the `Side` (LEFT or RIGHT) of the parent.
+type Edge struct {
+    Parent, Child interface{}
+    Side          Direction
+}
+
+// LabeledEdges returns the parent-child links of the tree, e.g. to export
+// it to graph tooling, in preorder: the links of a node come before those
+// of its children, the left link before the right one. So the first edge,
+// if any, starts at the root, the only key never found as a Child. A tree
+// of one node has no edges.
+func (t *Tree) LabeledEdges() []Edge {
+    edges := []Edge{}
+    var collect func(n *Node)
+    collect = func(n *Node) {
+        if !isNil(n.left) {
+            edges = append(edges, Edge{Parent: n.key, Child: n.left.key, Side: LEFT})
+            collect(n.left)
+        }
+        if !isNil(n.right) {
+            edges = append(edges, Edge{Parent: n.key, Child: n.right.key, Side: RIGHT})
+            collect(n.right)
+        }
+    }
+    if !isNil(t.root) {
+        collect(t.root)
+    }
+    return edges
+}
+
+// Edges returns the parent-child links as {parentKey, childKey} pairs,
+// in the order of LabeledEdges, which also tells left from right.
+func (t *Tree) Edges() [][2]interface{} {
+    labeled := t.LabeledEdges()
+    edges := make([][2]interface{}, len(labeled))
+    for i, edge := range labeled {
+        edges[i] = [2]interface{}{edge.Parent, edge.Child}
+    }
+    return edges
+}
+
+// ShapeString renders the structure of the tree in the notation of
+// InorderVisitor, with the color of every node appended to its key as
+// {B} or {R}, e.g. "((.1{R}.)2{B}(.3{R}.))". Two trees have the same
+// string exactly when they have the same shape, keys & colors, so it
+// serves as a golden string in tests. Keys are printed with %v.
+func (t *Tree) ShapeString() string {
+    var buf bytes.Buffer
+    var shape func(n *Node)
+    shape = func(n *Node) {
+        if isNil(n) {
+            buf.WriteString(".")
+            return
+        }
+        color := "R"
+        if n.color == BLACK {
+            color = "B"
+        }
+        buf.WriteString("(")
+        shape(n.left)
+        fmt.Fprintf(&buf, "%v{%s}", n.key, color)
+        shape(n.right)
+        buf.WriteString(")")
+    }
+    shape(t.root)
+    return buf.String()
+}
+
+// DepthHistogram maps each depth, the root being at depth 0, to the
+// number of nodes found at that depth, in a single traversal. It shows
+// how the nodes spread over the levels, e.g. to spot imbalance after many
+// deletes. An empty tree yields an empty map.
+func (t *Tree) DepthHistogram() map[int]uint64 {
+    histogram := map[int]uint64{}
+    var count func(n *Node, depth int)
+    count = func(n *Node, depth int) {
+        if isNil(n) {
+            return
+        }
+        histogram[depth]++
+        count(n.left, depth+1)
+        count(n.right, depth+1)
+    }
+    count(t.root, 0)
+    return histogram
+}
+
+// RotationCount puts `keys`, in the given order, into a fresh tree ordered
+// by `cmp` & returns the number of rotations the insertions took, to gauge
+// how costly an insertion order is to rebalance. Sorted input, for one,
+// rotates far more than shuffled input. Invalid keys are skipped.
+func RotationCount(cmp Comparator, keys []interface{}) uint64 {
+    t := NewTreeWith(cmp, WithOpCounters())
+    for _, key := range keys {
+        t.Put(key, nil)
+    }
+    return t.OpCounters().Rotations
+}
+
+// IsSorted walks the tree in order & checks that every key is strictly
+// greater than the previous one according to the comparator, i.e. the
+// binary search tree property on which lookups rely. It stops at the first
+// pair out of order, which it logs, & returns false; a corrupt tree or an
+// inconsistent comparator is then to blame.
+func (t *Tree) IsSorted() bool {
+    var previous *Node
+    return inorder(t.root, func(n *Node) bool {
+        if previous != nil && t.cmp(previous.key, n.key) >= 0 {
+            logger.Printf("IsSorted: %#v is not less than the following key %#v\n", previous.key, n.key)
+            return false
+        }
+        previous = n
+        return true
+    })
+}
@@ -2,6 +2,7 @@ package main
 
 import (
     "fmt"
+    "math"
     rbt "github.com/erriapo/redblacktree"
 )
 
@@ -54,4 +55,13 @@ func main() {
     tr.Put(kNZ, 666)
     fmt.Printf("tr.Put(kNZ, 666)\n")
     fmt.Printf("\tsize = %d\n", tr.Size())
+
+    fmt.Printf("\nSigned keys are ordered naturally\n")
+    ts := rbt.NewTree()
+    ts.Put(0, "zero")
+    ts.Put(-1, "minus one")
+    ts.Put(math.MinInt32, "min int32")
+    inorder3 := &rbt.InorderVisitor{}
+    ts.Walk(inorder3)
+    fmt.Printf("\ttree = %s\n", inorder3) // tree = ((.-2147483648.)-1(.0.))
 }
@@ -0,0 +1,79 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+// ComparatorE is a Comparator whose comparisons may fail, e.g. when keys
+// have to be parsed to be compared. It returns like a Comparator when the
+// error is nil.
+type ComparatorE func(o1, o2 interface{}) (int, error)
+
+// comparisonFailure carries the error of a ComparatorE up the stack,
+// as a panic, to the method which recovers it.
+type comparisonFailure struct {
+    err error
+}
+
+// NewTreeWithE returns an empty Tree ordered by the fallible comparator
+// `c`. Use PutE, GetE & DeleteE on it: they abort on the first failed
+// comparison, before the tree is modified, & return its error. The other
+// methods panic when a comparison fails.
+func NewTreeWithE(c ComparatorE, options ...Option) *Tree {
+    return NewTreeWith(func(o1, o2 interface{}) int {
+        result, err := c(o1, o2)
+        if err != nil {
+            panic(comparisonFailure{err})
+        }
+        return result
+    }, options...)
+}
+
+// recoverComparison turns the panic of a failed comparison into `*err`,
+// passing on any other panic.
+func recoverComparison(err *error) {
+    if r := recover(); r != nil {
+        failure, ok := r.(comparisonFailure)
+        if !ok {
+            panic(r)
+        }
+        logger.Printf("Comparison failed: %s\n", failure.err.Error())
+        *err = failure.err
+    }
+}
+
+// PutE is Put for trees created by NewTreeWithE: the error of a failed
+// comparison is returned & the tree is left unchanged.
+func (t *Tree) PutE(key interface{}, data interface{}) (err error) {
+    defer recoverComparison(&err)
+    return t.Put(key, data)
+}
+
+// GetE is Get for trees created by NewTreeWithE: the error of a failed
+// comparison is returned, with a nil payload & false.
+func (t *Tree) GetE(key interface{}) (value interface{}, ok bool, err error) {
+    defer recoverComparison(&err)
+    ok, value = t.Get(key)
+    return value, ok, nil
+}
+
+// DeleteE is Delete for trees created by NewTreeWithE: the error of a
+// failed comparison is returned & the tree is left unchanged.
+func (t *Tree) DeleteE(key interface{}) (err error) {
+    defer recoverComparison(&err)
+    t.Delete(key)
+    return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+// Iterator is a cursor over the entries of a tree in key order. The
+// cursor sits between two entries: Next returns the entry after it &
+// moves forward, Prev returns the entry before it & moves back, so that
+// Next followed by Prev returns the same entry twice. It walks the tree
+// through parent links, without any stack.
+//
+// Putting a new key or deleting any key invalidates the position of the
+// iterator; overwriting the payload of an existing key does not.
+type Iterator struct {
+    tree *Tree
+    prev *Node // entry before the cursor, nil at the start
+    next *Node // entry after the cursor, nil at the end
+}
+
+// Iterator returns an iterator positioned before the smallest key.
+func (t *Tree) Iterator() *Iterator {
+    it := &Iterator{tree: t}
+    if !isNil(t.root) {
+        it.next = t.getMinimum(t.root)
+    }
+    return it
+}
+
+// HasNext reports whether an entry follows the cursor.
+func (it *Iterator) HasNext() bool {
+    return it.next != nil
+}
+
+// HasPrev reports whether an entry precedes the cursor.
+func (it *Iterator) HasPrev() bool {
+    return it.prev != nil
+}
+
+// Next returns the entry after the cursor & moves past it. At the end
+// it returns nil, nil & stays put.
+func (it *Iterator) Next() (key, value interface{}) {
+    n := it.next
+    if n == nil {
+        return nil, nil
+    }
+    it.prev, it.next = n, it.tree.successor(n)
+    return n.key, n.payload
+}
+
+// Prev returns the entry before the cursor & moves back past it. At the
+// start it returns nil, nil & stays put.
+func (it *Iterator) Prev() (key, value interface{}) {
+    n := it.prev
+    if n == nil {
+        return nil, nil
+    }
+    it.prev, it.next = it.tree.predecessor(n), n
+    return n.key, n.payload
+}
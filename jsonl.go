@@ -0,0 +1,140 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "io"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// jsonEntry is one line of the JSON Lines format.
+type jsonEntry struct {
+    Key   interface{} `json:"key"`
+    Value interface{} `json:"value"`
+}
+
+// WriteJSONL streams the tree to `w` in the JSON Lines format: one
+// {"key":...,"value":...} object per line, in ascending key order.
+// Each line is written as soon as it is encoded, so the whole tree is
+// never held in memory as JSON.
+func (t *Tree) WriteJSONL(w io.Writer) error {
+    encoder := json.NewEncoder(w)
+    var err error
+    inorder(t.root, func(n *Node) bool {
+        err = encoder.Encode(jsonEntry{Key: toJSONNumber(n.key), Value: toJSONNumber(n.payload)})
+        return err == nil
+    })
+    return err
+}
+
+// ReadJSONL builds a tree ordered by `cmp` from the JSON Lines written by
+// WriteJSONL, reading `r` line by line. Input in ascending key order is
+// bulk-loaded balanced. JSON numbers, including those nested in objects &
+// arrays, decode as `int` when written without a fraction or exponent &
+// they fit, or as `float64` otherwise. WriteJSONL always writes a `float64`
+// with a fraction or exponent, e.g. 2.0, so that both round-trip.
+// The format holds no comparator: to restore a tree saved along with the
+// name of its comparator (see Tree.ComparatorName), pass
+// LookupComparator(name).
+func ReadJSONL(r io.Reader, cmp Comparator) (*Tree, error) {
+    var keys, values []interface{}
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(nil, 64*1024*1024)
+    for scanner.Scan() {
+        line := scanner.Bytes()
+        if len(line) == 0 {
+            continue
+        }
+        var entry jsonEntry
+        decoder := json.NewDecoder(bytes.NewReader(line))
+        decoder.UseNumber()
+        if err := decoder.Decode(&entry); err != nil {
+            return nil, err
+        }
+        keys = append(keys, fromJSONNumber(entry.Key))
+        values = append(values, fromJSONNumber(entry.Value))
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    return NewTreeFromSlices(cmp, keys, values)
+}
+
+// toJSONNumber gives every finite `float64` in v, nested ones included, a
+// fraction or an exponent, which encoding/json leaves out of whole values.
+func toJSONNumber(v interface{}) interface{} {
+    switch v := v.(type) {
+    case float64:
+        if math.IsInf(v, 0) || math.IsNaN(v) {
+            return v
+        }
+        s := strconv.FormatFloat(v, 'g', -1, 64)
+        if !strings.ContainsAny(s, ".eE") {
+            s += ".0"
+        }
+        return json.Number(s)
+    case map[string]interface{}:
+        converted := make(map[string]interface{}, len(v))
+        for k, e := range v {
+            converted[k] = toJSONNumber(e)
+        }
+        return converted
+    case []interface{}:
+        converted := make([]interface{}, len(v))
+        for i, e := range v {
+            converted[i] = toJSONNumber(e)
+        }
+        return converted
+    default:
+        return v
+    }
+}
+
+// fromJSONNumber turns every json.Number in v, nested ones included, back
+// into an `int` or a `float64`, see ReadJSONL.
+func fromJSONNumber(v interface{}) interface{} {
+    switch v := v.(type) {
+    case json.Number:
+        if !strings.ContainsAny(string(v), ".eE") {
+            if i, err := strconv.ParseInt(string(v), 10, strconv.IntSize); err == nil {
+                return int(i)
+            }
+        }
+        if f, err := v.Float64(); err == nil {
+            return f
+        }
+        return string(v)
+    case map[string]interface{}:
+        for k, e := range v {
+            v[k] = fromJSONNumber(e)
+        }
+        return v
+    case []interface{}:
+        for i, e := range v {
+            v[i] = fromJSONNumber(e)
+        }
+        return v
+    default:
+        return v
+    }
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import "strings"
+
+// NewPrefixTree returns an empty Tree for routing by byte prefixes. Slice
+// keys are disallowed, so each prefix is stored as its `string`
+// conversion, e.g. `t.Put(string(prefix), route)`; StringComparator then
+// orders the keys exactly as `bytes.Compare` orders the prefixes.
+func NewPrefixTree() *Tree {
+    return NewTreeWith(StringComparator)
+}
+
+// LongestPrefixMatch returns the longest stored key that is a prefix of
+// `key`, together with its payload. The empty key is a prefix of every
+// `key` & a key equal to `key` counts as a prefix of it. It returns false
+// when no stored key is a prefix of `key`. The tree must have been created
+// by NewPrefixTree or otherwise hold `string` keys under StringComparator.
+//
+// Every prefix of `key` sorts at or before `key`, so the search starts
+// from the floor of `key`. When the floor is not a prefix, no stored key
+// longer than their common prefix can match either, so the query is cut
+// down to that common prefix & the search repeats.
+func (t *Tree) LongestPrefixMatch(key []byte) ([]byte, interface{}, bool) {
+    q := string(key)
+    for {
+        n := t.floorNode(q)
+        if n == nil {
+            return nil, nil, false
+        }
+        s := n.key.(string)
+        if strings.HasPrefix(q, s) {
+            return []byte(s), n.payload, true
+        }
+        i := 0
+        for i < len(s) && i < len(q) && s[i] == q[i] {
+            i++
+        }
+        q = q[:i]
+    }
+}
@@ -0,0 +1,676 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import (
+    "math"
+    "strings"
+)
+
+// KeysBetween visits, in ascending order, the keys lying between `lo` and
+// `hi`. The flags `loInc` & `hiInc` decide whether either endpoint is part of
+// the range, so that [lo,hi), (lo,hi], (lo,hi) & [lo,hi] can all be expressed.
+// The walk stops as soon as `fn` returns false. Subtrees entirely outside
+// the range are never descended into.
+func (t *Tree) KeysBetween(lo interface{}, loInc bool, hi interface{}, hiInc bool, fn func(key interface{}) bool) {
+    if err := mustBeValidKey(lo); err != nil {
+        logger.Printf("KeysBetween was prematurely aborted: %s\n", err.Error())
+        return
+    }
+    if err := mustBeValidKey(hi); err != nil {
+        logger.Printf("KeysBetween was prematurely aborted: %s\n", err.Error())
+        return
+    }
+    if fn == nil {
+        return
+    }
+    t.nodesBetween(t.root, lo, loInc, hi, hiInc, func(n *Node) bool {
+        return fn(n.key)
+    })
+}
+
+// nodesBetween calls `fn` on the nodes of the subtree rooted at n whose
+// keys lie between `lo` & `hi`, in ascending order. It returns false
+// once `fn` asked to stop.
+func (t *Tree) nodesBetween(n *Node, lo interface{}, loInc bool, hi interface{}, hiInc bool, fn func(*Node) bool) bool {
+    if isNil(n) {
+        return true
+    }
+    cmpLo, cmpHi := t.cmp(n.key, lo), t.cmp(n.key, hi)
+    if cmpLo > 0 {
+        if !t.nodesBetween(n.left, lo, loInc, hi, hiInc, fn) {
+            return false
+        }
+    }
+    aboveLo := cmpLo > 0 || (cmpLo == 0 && loInc)
+    belowHi := cmpHi < 0 || (cmpHi == 0 && hiInc)
+    if aboveLo && belowHi {
+        if !fn(n) {
+            return false
+        }
+    }
+    if cmpHi < 0 {
+        return t.nodesBetween(n.right, lo, loInc, hi, hiInc, fn)
+    }
+    return true
+}
+
+// floorNode returns the node with the greatest key less than or equal
+// to `key`, or nil if there is none.
+func (t *Tree) floorNode(key interface{}) *Node {
+    var floor *Node
+    for n := t.root; !isNil(n); {
+        switch c := t.cmp(key, n.key); {
+        case c < 0:
+            n = n.left
+        case c > 0:
+            floor, n = n, n.right
+        default:
+            return n
+        }
+    }
+    return floor
+}
+
+// ceilingNode returns the node with the least key greater than or equal
+// to `key`, or nil if there is none.
+func (t *Tree) ceilingNode(key interface{}) *Node {
+    var ceiling *Node
+    for n := t.root; !isNil(n); {
+        switch c := t.cmp(key, n.key); {
+        case c < 0:
+            ceiling, n = n, n.left
+        case c > 0:
+            n = n.right
+        default:
+            return n
+        }
+    }
+    return ceiling
+}
+
+// GetClosest returns the entry for `key` if present. Otherwise it returns
+// whichever of the floor & the ceiling of `key` is closer, as measured by
+// the Distance given through WithDistance. Without a Distance, or on a tie,
+// the floor wins. The boolean is false only when the tree is empty.
+func (t *Tree) GetClosest(key interface{}) (*Entry, bool) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("GetClosest was prematurely aborted: %s\n", err.Error())
+        return nil, false
+    }
+
+    floor, ceiling := t.floorNode(key), t.ceilingNode(key)
+    var closest *Node
+    switch {
+    case floor == nil:
+        closest = ceiling
+    case ceiling == nil:
+        closest = floor
+    case t.distance != nil && t.distance(key, ceiling.key) < t.distance(key, floor.key):
+        closest = ceiling
+    default:
+        closest = floor
+    }
+    if closest == nil {
+        return nil, false
+    }
+    return &Entry{Key: closest.key, Value: closest.payload}, true
+}
+
+// ClosestByValue returns the entry whose payload, mapped to a number by
+// `value`, is nearest to `target`; on a tie the smallest key wins. Payloads
+// are not ordered, so unlike GetClosest this scans every entry: it takes
+// O(n) time. The boolean is false when the tree is empty.
+func (t *Tree) ClosestByValue(target float64, value func(interface{}) float64) (*Entry, bool) {
+    var closest *Node
+    var best float64
+    inorder(t.root, func(n *Node) bool {
+        if d := math.Abs(value(n.payload) - target); closest == nil || d < best {
+            closest, best = n, d
+        }
+        return true
+    })
+    if closest == nil {
+        return nil, false
+    }
+    return &Entry{Key: closest.key, Value: closest.payload}, true
+}
+
+// HasInRange reports whether any key lies in the closed range [lo,hi].
+// The descent stops at the first such key, skipping whole subtrees below
+// `lo` or above `hi`. An inverted range (lo > hi) holds no keys.
+func (t *Tree) HasInRange(lo, hi interface{}) bool {
+    if err := mustBeValidKey(lo); err != nil {
+        logger.Printf("HasInRange was prematurely aborted: %s\n", err.Error())
+        return false
+    }
+    if err := mustBeValidKey(hi); err != nil {
+        logger.Printf("HasInRange was prematurely aborted: %s\n", err.Error())
+        return false
+    }
+    if isNil(t.root) || t.cmp(lo, hi) > 0 {
+        return false
+    }
+
+    for n := t.root; !isNil(n); {
+        switch {
+        case t.cmp(n.key, lo) < 0:
+            n = n.right
+        case t.cmp(n.key, hi) > 0:
+            n = n.left
+        default:
+            return true
+        }
+    }
+    return false
+}
+
+// SumRange adds up `value(payload)` over the entries whose keys lie in
+// the closed range [lo,hi]. Subtrees outside the range are skipped.
+// Empty & inverted ranges sum to 0.
+func (t *Tree) SumRange(lo, hi interface{}, value func(interface{}) float64) float64 {
+    if err := mustBeValidKey(lo); err != nil {
+        logger.Printf("SumRange was prematurely aborted: %s\n", err.Error())
+        return 0
+    }
+    if err := mustBeValidKey(hi); err != nil {
+        logger.Printf("SumRange was prematurely aborted: %s\n", err.Error())
+        return 0
+    }
+
+    var sum float64
+    t.nodesBetween(t.root, lo, true, hi, true, func(n *Node) bool {
+        sum = sum + value(n.payload)
+        return true
+    })
+    return sum
+}
+
+// higherNode returns the node with the least key strictly greater
+// than `key`, or nil if there is none.
+func (t *Tree) higherNode(key interface{}) *Node {
+    var higher *Node
+    for n := t.root; !isNil(n); {
+        if t.cmp(key, n.key) < 0 {
+            higher, n = n, n.left
+        } else {
+            n = n.right
+        }
+    }
+    return higher
+}
+
+// successor returns the node following n in key order, or nil
+// when n holds the largest key.
+func (t *Tree) successor(n *Node) *Node {
+    if !isNil(n.right) {
+        return t.getMinimum(n.right)
+    }
+    p := n.parent
+    for !isNil(p) && n == p.right {
+        n, p = p, p.parent
+    }
+    if isNil(p) {
+        return nil
+    }
+    return p
+}
+
+// predecessor returns the node preceding n in key order, or nil
+// when n holds the smallest key.
+func (t *Tree) predecessor(n *Node) *Node {
+    if !isNil(n.left) {
+        return t.getMaximum(n.left)
+    }
+    p := n.parent
+    for !isNil(p) && n == p.left {
+        n, p = p, p.parent
+    }
+    if isNil(p) {
+        return nil
+    }
+    return p
+}
+
+// KthAfter returns the entry with the kth key strictly greater than `key`;
+// k = 1 is the immediate successor. It returns false when fewer than k
+// keys are greater than `key`, or when k < 1.
+func (t *Tree) KthAfter(key interface{}, k int) (*Entry, bool) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("KthAfter was prematurely aborted: %s\n", err.Error())
+        return nil, false
+    }
+    if k < 1 {
+        return nil, false
+    }
+
+    n := t.higherNode(key)
+    for i := 1; i < k && n != nil; i++ {
+        n = t.successor(n)
+    }
+    if n == nil {
+        return nil, false
+    }
+    return &Entry{Key: n.key, Value: n.payload}, true
+}
+
+// FloorValue returns the payload of the greatest key less than or equal
+// to `key`. It returns false when there is no such key.
+func (t *Tree) FloorValue(key interface{}) (interface{}, bool) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("FloorValue was prematurely aborted: %s\n", err.Error())
+        return nil, false
+    }
+    if n := t.floorNode(key); n != nil {
+        return n.payload, true
+    }
+    return nil, false
+}
+
+// CeilingValue returns the payload of the least key greater than or equal
+// to `key`. It returns false when there is no such key.
+func (t *Tree) CeilingValue(key interface{}) (interface{}, bool) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("CeilingValue was prematurely aborted: %s\n", err.Error())
+        return nil, false
+    }
+    if n := t.ceilingNode(key); n != nil {
+        return n.payload, true
+    }
+    return nil, false
+}
+
+// Bucket maps `key` to the bucket it falls into, where each key of the
+// tree is the inclusive upper boundary of a bucket & its payload is the
+// configuration of that bucket. It returns the least boundary greater than
+// or equal to `key` with its payload, and false when `key` lies beyond
+// the last boundary. Bucket is a ceiling lookup under another name, e.g.
+//
+//    t.Put(60, "per minute")
+//    t.Put(3600, "per hour")
+//    t.Bucket(90) // 3600, "per hour", true
+func (t *Tree) Bucket(key interface{}) (bucketKey, value interface{}, ok bool) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("Bucket was prematurely aborted: %s\n", err.Error())
+        return nil, nil, false
+    }
+    if n := t.ceilingNode(key); n != nil {
+        return n.key, n.payload, true
+    }
+    return nil, nil, false
+}
+
+// ExtractRange removes the entries whose keys lie in the closed range
+// [lo,hi] & returns them in ascending key order, e.g. to move a key range
+// to another tree with NewTreeFromSlices. Each removal rebalances the tree
+// like Delete. An empty or inverted range removes nothing.
+func (t *Tree) ExtractRange(lo, hi interface{}) []Entry {
+    entries := []Entry{}
+    if err := mustBeValidKey(lo); err != nil {
+        logger.Printf("ExtractRange was prematurely aborted: %s\n", err.Error())
+        return entries
+    }
+    if err := mustBeValidKey(hi); err != nil {
+        logger.Printf("ExtractRange was prematurely aborted: %s\n", err.Error())
+        return entries
+    }
+
+    t.nodesBetween(t.root, lo, true, hi, true, func(n *Node) bool {
+        entries = append(entries, Entry{Key: n.key, Value: n.payload})
+        return true
+    })
+    for _, entry := range entries {
+        t.Delete(entry.Key)
+    }
+    return entries
+}
+
+// RankOf returns how many stored keys are strictly less than `key`,
+// whether `key` is present or not: the position `key` has, or would have
+// once put, in ascending order. As nodes do not record the size of their
+// subtree, the keys below `key` are counted one by one.
+func (t *Tree) RankOf(key interface{}) uint64 {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("RankOf was prematurely aborted: %s\n", err.Error())
+        return 0
+    }
+
+    var rank uint64
+    inorder(t.root, func(n *Node) bool {
+        if t.cmp(n.key, key) >= 0 {
+            return false
+        }
+        rank++
+        return true
+    })
+    return rank
+}
+
+// Surrounding returns the entries with the greatest key less than or equal
+// to `key` & with the least key greater than or equal to `key`, found in a
+// single descent, e.g. to interpolate between two bracketing keys. Either
+// is nil when there is no such key. When `key` is present, both hold the
+// same entry.
+func (t *Tree) Surrounding(key interface{}) (floor, ceiling *Entry) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("Surrounding was prematurely aborted: %s\n", err.Error())
+        return nil, nil
+    }
+
+    var below, above *Node
+    for n := t.root; !isNil(n); {
+        switch c := t.cmp(key, n.key); {
+        case c < 0:
+            above, n = n, n.left
+        case c > 0:
+            below, n = n, n.right
+        default:
+            below, above = n, n
+            n = nil
+        }
+    }
+    if below != nil {
+        floor = &Entry{Key: below.key, Value: below.payload}
+    }
+    if above != nil {
+        ceiling = &Entry{Key: above.key, Value: above.payload}
+    }
+    return floor, ceiling
+}
+
+// Page returns up to `limit` entries, in ascending order, whose keys are
+// strictly greater than `afterKey`, starting from the smallest key when
+// `afterKey` is nil. It also returns the cursor to pass as `afterKey` for
+// the next page, i.e. the last key returned (or `afterKey` itself when the
+// page is empty), & whether entries remain beyond this page:
+//
+//    var cursor interface{}
+//    for more := true; more; {
+//        var page []Entry
+//        page, cursor, more = t.Page(cursor, 100)
+//        ...
+//    }
+//
+// Unlike offsets, the cursor stays valid when keys are put or deleted
+// between calls. A `limit` < 1 returns an empty page & false, so that such
+// a loop ends rather than spinning on empty pages.
+func (t *Tree) Page(afterKey interface{}, limit int) ([]Entry, interface{}, bool) {
+    entries := []Entry{}
+    if limit < 1 {
+        return entries, afterKey, false
+    }
+    var n *Node
+    if afterKey == nil {
+        if !isNil(t.root) {
+            n = t.getMinimum(t.root)
+        }
+    } else {
+        if err := mustBeValidKey(afterKey); err != nil {
+            logger.Printf("Page was prematurely aborted: %s\n", err.Error())
+            return entries, afterKey, false
+        }
+        n = t.higherNode(afterKey)
+    }
+
+    cursor := afterKey
+    for ; n != nil && len(entries) < limit; n = t.successor(n) {
+        entries = append(entries, Entry{Key: n.key, Value: n.payload})
+        cursor = n.key
+    }
+    return entries, cursor, n != nil
+}
+
+// IndexOf returns the 0-based position of the node `n`, e.g. one handed
+// to a Visitor, in ascending key order. The path from `n` up to the root
+// is checked link by link; ErrorNodeNotInTree is returned when `n` is nil,
+// was deleted or belongs to another tree. As nodes do not record the size
+// of their subtree, the nodes before `n` are counted one by one.
+func (t *Tree) IndexOf(n *Node) (uint64, error) {
+    if isNil(n) {
+        return 0, ErrorNodeNotInTree
+    }
+    counter := &countingVisitor{}
+    counter.Visit(n.left)
+    for child, p := n, n.parent; child != t.root; child, p = p, p.parent {
+        switch {
+        case isNil(p):
+            return 0, ErrorNodeNotInTree
+        case p.right == child:
+            counter.Count++
+            counter.Visit(p.left)
+        case p.left != child:
+            return 0, ErrorNodeNotInTree
+        }
+    }
+    return counter.Count, nil
+}
+
+// RangeWalkDescending calls `fn` on the entries whose keys lie in the
+// closed range [lo,hi], from the highest key down to the lowest, e.g. to
+// list the most recent events of a window. The walk stops as soon as `fn`
+// returns false. Subtrees entirely outside the range are never descended
+// into. An empty or inverted range visits nothing.
+// It takes a callback rather than a Visitor, like WalkInsertionOrder: a
+// Visitor is handed the root & descends on its own, so the order & the
+// bounds of the walk would be up to it, not to the tree.
+func (t *Tree) RangeWalkDescending(lo, hi interface{}, fn func(key, value interface{}) bool) {
+    if err := mustBeValidKey(lo); err != nil {
+        logger.Printf("RangeWalkDescending was prematurely aborted: %s\n", err.Error())
+        return
+    }
+    if err := mustBeValidKey(hi); err != nil {
+        logger.Printf("RangeWalkDescending was prematurely aborted: %s\n", err.Error())
+        return
+    }
+    if fn == nil {
+        return
+    }
+    t.nodesBetweenDescending(t.root, lo, hi, func(n *Node) bool {
+        return fn(n.key, n.payload)
+    })
+}
+
+// nodesBetweenDescending is the mirror image of nodesBetween over the
+// closed range [lo,hi]: right subtrees are visited before left ones.
+func (t *Tree) nodesBetweenDescending(n *Node, lo, hi interface{}, fn func(*Node) bool) bool {
+    if isNil(n) {
+        return true
+    }
+    cmpLo, cmpHi := t.cmp(n.key, lo), t.cmp(n.key, hi)
+    if cmpHi < 0 {
+        if !t.nodesBetweenDescending(n.right, lo, hi, fn) {
+            return false
+        }
+    }
+    if cmpLo >= 0 && cmpHi <= 0 {
+        if !fn(n) {
+            return false
+        }
+    }
+    if cmpLo > 0 {
+        return t.nodesBetweenDescending(n.left, lo, hi, fn)
+    }
+    return true
+}
+
+// Head returns the first `n` entries in ascending key order, or all of
+// them when the tree holds fewer. Only the entries returned are visited,
+// so it is cheap on huge trees, e.g. to log a preview.
+func (t *Tree) Head(n int) []Entry {
+    entries := []Entry{}
+    for node := t.minimum(); node != nil && len(entries) < n; node = t.successor(node) {
+        entries = append(entries, Entry{Key: node.key, Value: node.payload})
+    }
+    return entries
+}
+
+// Tail returns the last `n` entries, also in ascending key order, or all
+// of them when the tree holds fewer. Like Head, it only visits the entries
+// returned.
+func (t *Tree) Tail(n int) []Entry {
+    entries := []Entry{}
+    for node := t.maximum(); node != nil && len(entries) < n; node = t.predecessor(node) {
+        entries = append(entries, Entry{Key: node.key, Value: node.payload})
+    }
+    for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+        entries[i], entries[j] = entries[j], entries[i]
+    }
+    return entries
+}
+
+// EqualGroup returns, in ascending order, the entries whose keys the
+// primary comparator of a tree created by NewTreeWith2 considers equal to
+// `key`, whatever the tiebreak says, e.g. all events at one timestamp.
+// Equal keys are contiguous in key order, so only the subtrees that may
+// hold some of them are visited. Without a tiebreak, it returns the entry
+// of `key` alone, if present.
+func (t *Tree) EqualGroup(key interface{}) []Entry {
+    entries := []Entry{}
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("EqualGroup was prematurely aborted: %s\n", err.Error())
+        return entries
+    }
+    primary := t.primary
+    if primary == nil {
+        primary = t.cmp
+    }
+
+    var collect func(n *Node)
+    collect = func(n *Node) {
+        if isNil(n) {
+            return
+        }
+        switch c := primary(n.key, key); {
+        case c < 0:
+            collect(n.right)
+        case c > 0:
+            collect(n.left)
+        default:
+            collect(n.left)
+            entries = append(entries, Entry{Key: n.key, Value: n.payload})
+            collect(n.right)
+        }
+    }
+    collect(t.root)
+    return entries
+}
+
+// GroupBy walks the tree in order & calls `fn` once per run of
+// consecutive entries whose keys `bucketOf` maps to the same bucket, e.g.
+// the hour of a timestamp key. Buckets are compared with ==, so they must
+// be comparable values. `bucketOf` must be monotonic with the key order:
+// if it is not, a bucket whose keys are not contiguous is reported once
+// per run. The entries slice given to `fn` is not reused.
+func (t *Tree) GroupBy(bucketOf func(key interface{}) interface{}, fn func(bucket interface{}, entries []Entry)) {
+    var bucket interface{}
+    var group []Entry
+    inorder(t.root, func(n *Node) bool {
+        b := bucketOf(n.key)
+        if len(group) > 0 && b != bucket {
+            fn(bucket, group)
+            group = nil
+        }
+        bucket = b
+        group = append(group, Entry{Key: n.key, Value: n.payload})
+        return true
+    })
+    if len(group) > 0 {
+        fn(bucket, group)
+    }
+}
+
+// PrefixScan calls `fn`, in ascending order, on the entries whose keys
+// start with `prefix`, stopping early once `fn` returns false, e.g. for
+// autocompletion. It only makes sense for `string` keys ordered byte-wise,
+// as by StringComparator, under which such keys are contiguous from the
+// ceiling of `prefix` on; the keys are type-asserted to `string`, which
+// panics on keys of another type.
+func (t *Tree) PrefixScan(prefix string, fn func(key string, value interface{}) bool) {
+    if fn == nil {
+        return
+    }
+    for n := t.ceilingNode(prefix); n != nil; n = t.successor(n) {
+        key := n.key.(string)
+        if !strings.HasPrefix(key, prefix) || !fn(key, n.payload) {
+            return
+        }
+    }
+}
+
+// Retain deletes every entry whose key lies outside the closed window
+// [lo,hi] & returns how many were removed, e.g. to keep only the last hour
+// of a time-keyed cache. Each removal rebalances the tree like Delete. An
+// inverted window (lo > hi) holds no keys, so everything is removed.
+func (t *Tree) Retain(lo, hi interface{}) uint64 {
+    if err := mustBeValidKey(lo); err != nil {
+        logger.Printf("Retain was prematurely aborted: %s\n", err.Error())
+        return 0
+    }
+    if err := mustBeValidKey(hi); err != nil {
+        logger.Printf("Retain was prematurely aborted: %s\n", err.Error())
+        return 0
+    }
+
+    var outside []interface{}
+    for n := t.minimum(); n != nil && t.cmp(n.key, lo) < 0; n = t.successor(n) {
+        outside = append(outside, n.key)
+    }
+    for n := t.maximum(); n != nil && t.cmp(n.key, hi) > 0 && t.cmp(n.key, lo) >= 0; n = t.predecessor(n) {
+        outside = append(outside, n.key)
+    }
+    for _, key := range outside {
+        t.Delete(key)
+    }
+    return uint64(len(outside))
+}
+
+// ReplicationBatch is Page bounded by size rather than count: it returns,
+// in ascending order, the entries after `afterKey` (from the smallest key
+// when nil) as long as the sum of `valueSize(payload)` stays within
+// `maxBytes`, with the cursor for the next batch & whether entries remain,
+// so that messages to a replica stay capped. A value larger than
+// `maxBytes` on its own is still emitted, alone in its batch, so that
+// replication never stalls on it.
+func (t *Tree) ReplicationBatch(afterKey interface{}, maxBytes int, valueSize func(interface{}) int) ([]Entry, interface{}, bool) {
+    entries := []Entry{}
+    var n *Node
+    if afterKey == nil {
+        n = t.minimum()
+    } else {
+        if err := mustBeValidKey(afterKey); err != nil {
+            logger.Printf("ReplicationBatch was prematurely aborted: %s\n", err.Error())
+            return entries, afterKey, false
+        }
+        n = t.higherNode(afterKey)
+    }
+
+    cursor, bytes := afterKey, 0
+    for ; n != nil; n = t.successor(n) {
+        size := valueSize(n.payload)
+        if len(entries) > 0 && bytes+size > maxBytes {
+            break
+        }
+        entries = append(entries, Entry{Key: n.key, Value: n.payload})
+        cursor, bytes = n.key, bytes+size
+        if bytes >= maxBytes {
+            n = t.successor(n)
+            break
+        }
+    }
+    return entries, cursor, n != nil
+}
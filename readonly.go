@@ -0,0 +1,91 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+// ReadOnlyTree is the read-only subset of the methods of Tree.
+type ReadOnlyTree interface {
+    Visitable
+    Get(key interface{}) (bool, interface{})
+    Has(key interface{}) bool
+    Size() uint64
+    Keys() []interface{}
+    Values() []interface{}
+    Min() (*Entry, bool)
+    Max() (*Entry, bool)
+    Each(fn func(key, value interface{}) bool)
+}
+
+// readOnlyTree hides the mutating methods of the tree it wraps,
+// including from a type assertion back to *Tree.
+type readOnlyTree struct {
+    tree *Tree
+}
+
+// ReadOnly returns a view of the tree which cannot be used to modify it.
+// Nothing is copied but the root node handed to visitors by Walk, so the
+// view is cheap; it also means that changes made through the original
+// *Tree remain visible through the view.
+func (t *Tree) ReadOnly() ReadOnlyTree {
+    return readOnlyTree{tree: t}
+}
+
+// Walk hands the visitor a copy of the root node, so that a visitor
+// calling e.g. SetColor cannot break the tree behind the view. Outside
+// this package, a visitor can only reach other nodes by going up from the
+// node it is given, which leads nowhere from the root, so nothing else
+// needs copying.
+func (r readOnlyTree) Walk(visitor Visitor) {
+    if isNil(r.tree.root) {
+        visitor.Visit(nil)
+        return
+    }
+    root := *r.tree.root
+    visitor.Visit(&root)
+}
+
+func (r readOnlyTree) Get(key interface{}) (bool, interface{}) {
+    return r.tree.Get(key)
+}
+
+func (r readOnlyTree) Has(key interface{}) bool {
+    return r.tree.Has(key)
+}
+
+func (r readOnlyTree) Size() uint64 {
+    return r.tree.Size()
+}
+
+func (r readOnlyTree) Keys() []interface{} {
+    return r.tree.Keys()
+}
+
+func (r readOnlyTree) Values() []interface{} {
+    return r.tree.Values()
+}
+
+func (r readOnlyTree) Min() (*Entry, bool) {
+    return r.tree.Min()
+}
+
+func (r readOnlyTree) Max() (*Entry, bool) {
+    return r.tree.Max()
+}
+
+func (r readOnlyTree) Each(fn func(key, value interface{}) bool) {
+    r.tree.Each(fn)
+}
@@ -30,8 +30,11 @@ import (
     "log"
     "os"
     "reflect"
+    "sort"
     "strings"
     "sync"
+    "sync/atomic"
+    "unsafe"
 )
 
 // Color of a redblack tree node is either 
@@ -81,16 +84,64 @@ type Node struct {
     left   *Node
     right  *Node
     parent *Node
+    meta   *nodeMeta // allocated by the options which need it only
+}
+
+// nodeMeta holds the bookkeeping of a node which only some trees want,
+// so that the others do not pay for it on every node.
+type nodeMeta struct {
+    seq    uint64 // insertion sequence number, see WithInsertionSeq
+    access uint64 // recency of the last Get or Put, see WithAccessTracking
+}
+
+// metadata returns the bookkeeping of n, allocating it on first use.
+func (n *Node) metadata() *nodeMeta {
+    if n.meta == nil {
+        n.meta = &nodeMeta{}
+    }
+    return n.meta
 }
 
 func (n *Node) String() string {
     return fmt.Sprintf("(%#v : %s)", n.key, n.Color())
 }
 
+// Parent returns nil for the root node.
 func (n *Node) Parent() *Node {
+    if isNil(n.parent) {
+        return nil
+    }
     return n.parent
 }
 
+// Sibling returns the other child of the parent of n. It returns nil
+// for the root & when the other child is a leaf.
+func (n *Node) Sibling() *Node {
+    p := n.Parent()
+    if p == nil {
+        return nil
+    }
+    sibling := p.left
+    if n == p.left {
+        sibling = p.right
+    }
+    if isNil(sibling) {
+        return nil
+    }
+    return sibling
+}
+
+// Uncle returns the sibling of the parent of n, called `y` in the fixup
+// after a Put. It returns nil when n has no grandparent & when the uncle
+// is a leaf.
+func (n *Node) Uncle() *Node {
+    p := n.Parent()
+    if p == nil {
+        return nil
+    }
+    return p.Sibling()
+}
+
 func (n *Node) SetColor(color Color) {
     n.color = color
 }
@@ -99,6 +150,23 @@ func (n *Node) Color() Color {
     return n.color
 }
 
+// Seq returns the insertion sequence number of the node, starting at 1
+// for the first Put. It is 0 unless the tree was created with
+// WithInsertionSeq, and for nodes built in bulk, e.g. by Filter.
+func (n *Node) Seq() uint64 {
+    if n.meta == nil {
+        return 0
+    }
+    return n.meta.seq
+}
+
+// isNil reports whether n stands for an empty subtree: either a nil
+// pointer or the sentinel leaf of a tree. The sentinel is the only
+// node without a key, as the literal nil is never a valid key.
+func isNil(n *Node) bool {
+    return n == nil || n.key == nil
+}
+
 type Visitor interface {
     Visit(*Node)
 }
@@ -133,16 +201,172 @@ func StringComparator(o1, o2 interface{}) int {
     return bytes.Compare([]byte(s1), []byte(s2))
 }
 
+// Keys of type `float64`.
+// Warning: if either one of `o1` or `o2` cannot be asserted to `float64`, it panics.
+func Float64Comparator(o1, o2 interface{}) int {
+    f1 := o1.(float64); f2 := o2.(float64)
+    switch {
+    case f1 > f2:
+        return 1
+    case f1 < f2:
+        return -1
+    default:
+        return 0
+    }
+}
+
+// ComparatorFromLessFunc turns a "less than" function, like the one
+// given to `sort.Slice`, into a Comparator. To migrate a call site such as
+//
+//    sort.Slice(people, func(i, j int) bool { return people[i].Age < people[j].Age })
+//
+// lift the comparison out of the indices & onto the elements:
+//
+//    byAge := ComparatorFromLessFunc(func(a, b interface{}) bool {
+//        return a.(Person).Age < b.(Person).Age
+//    })
+//    tree := NewTreeWith(byAge)
+//
+// Keys for which neither is less than the other are considered equal,
+// so they map to the same entry of the tree.
+func ComparatorFromLessFunc(less func(a, b interface{}) bool) Comparator {
+    return func(o1, o2 interface{}) int {
+        switch {
+        case less(o1, o2):
+            return -1
+        case less(o2, o1):
+            return 1
+        default:
+            return 0
+        }
+    }
+}
+
+// ComparatorByField orders keys by the part of them picked out by
+// `extract`, compared with `base`. To order structs by an int field:
+//
+//    byTimestamp := ComparatorByField(func(o interface{}) interface{} {
+//        return o.(Event).Timestamp
+//    }, IntComparator)
+//
+// Keys with equal extracted parts are considered equal, so they map to
+// the same entry of the tree; see NewTreeWith2 for a tiebreak.
+func ComparatorByField(extract func(interface{}) interface{}, base Comparator) Comparator {
+    return func(o1, o2 interface{}) int {
+        return base(extract(o1), extract(o2))
+    }
+}
+
+// NilSafeComparator orders nil before everything else, whether the literal
+// nil or a typed nil such as a nil pointer, two nils being equal, & leaves
+// the comparison of two non-nil arguments to `base`, which then never has
+// to deal with nil. As nil is not a valid key, this matters for the parts
+// of keys compared, e.g. a pointer field picked by ComparatorByField.
+func NilSafeComparator(base Comparator) Comparator {
+    return func(o1, o2 interface{}) int {
+        nil1, nil2 := isNilValue(o1), isNilValue(o2)
+        switch {
+        case nil1 && nil2:
+            return 0
+        case nil1:
+            return -1
+        case nil2:
+            return 1
+        default:
+            return base(o1, o2)
+        }
+    }
+}
+
+// isNilValue reports whether o is nil, typed or not.
+func isNilValue(o interface{}) bool {
+    if o == nil {
+        return true
+    }
+    v := reflect.ValueOf(o)
+    switch v.Kind() {
+    case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+        return v.IsNil()
+    default:
+        return false
+    }
+}
+
+// ComparatorsAgree reports whether `a` & `b` order every pair of `samples`
+// the same way, i.e. give results of the same sign, e.g. before combining
+// the content of two trees. It is a heuristic check: agreeing on samples
+// does not prove that the comparators agree on all keys, but it catches
+// common mistakes such as reversed or differently keyed orderings.
+func ComparatorsAgree(a, b Comparator, samples []interface{}) bool {
+    for _, o1 := range samples {
+        for _, o2 := range samples {
+            if sign(a(o1, o2)) != sign(b(o1, o2)) {
+                return false
+            }
+        }
+    }
+    return true
+}
+
 // Tree encapsulates the data structure.
+// Like T.nil in CLRS, every leaf of the tree is a single shared black
+// sentinel node; the parent of the root is the sentinel as well.
 type Tree struct {
     root *Node     // tip of the tree
     cmp Comparator // required function to order keys
+    sentinel *Node // shared black leaf, lazily created
+    checkCmp bool  // debug mode: sanity check `cmp` on every Put
+    distance Distance // optional, used by GetClosest
+    fixupTrace []string // fixup cases of the last Put or Delete
+    spare []Node        // preallocated nodes, see WithCapacity
+    onOverwrite func(key, oldValue, newValue interface{})
+    rejectNil bool      // Put refuses nil payloads
+    auto bool           // `cmp` is picked by the first Put, see NewTreeAuto
+    counters *OpCounters // nil unless WithOpCounters
+    seqOn bool           // stamp nodes with a sequence number, see WithInsertionSeq
+    seqRefresh bool      // an overwrite stamps the node anew
+    lastSeq uint64       // sequence number of the latest stamp
+    minNode *Node        // cached node of the least key, nil when unknown
+    maxNode *Node        // cached node of the greatest key, nil when unknown
+    compareForm KeyTransformer // applied to keys before `cmp`, see WithKeyTransformer
+    cmpName string       // registered name of `cmp`, see NewTreeNamed
+    primary Comparator   // `cmp` without its tiebreak, see NewTreeWith2
+    trackAccess bool     // Get & Put stamp nodes, see WithAccessTracking
+    lastAccess uint64    // recency of the latest access
+    countCmp bool        // debug mode: count the comparisons of lookups
+    comparisons int      // comparisons of the last lookup, see LastComparisonCount
+    sameKind bool        // Put rejects keys of another kind than the stored ones
+}
+
+// Distance measures how far apart two keys are. It must never be negative.
+type Distance func(o1, o2 interface{}) float64
+
+// Entry is a key together with its mapped payload.
+type Entry struct {
+    Key, Value interface{}
+}
+
+// Option configures a Tree when it is constructed.
+type Option func(*Tree)
+
+// WithComparatorCheck turns on a debug mode where every Put checks that
+// the comparator is reflexive, antisymmetric & transitive against the keys
+// met on the way down. A violation is logged & then panics. The check
+// costs extra comparisons, so production trees should leave it off.
+func WithComparatorCheck() Option {
+    return func(t *Tree) {
+        t.checkCmp = true
+    }
 }
 
 // `lock` protects `logger`
 var lock sync.Mutex
 var logger *log.Logger
 
+// traced is 1 while `logger` does not discard its output, read without
+// taking `lock` by the fixups of every Put & Delete.
+var traced int32
+
 func init() {
     logger = log.New(ioutil.Discard, "", log.LstdFlags)
 }
@@ -160,20 +384,295 @@ func TraceOff() {
 
 // SetOutput redirects log output
 func SetOutput(w io.Writer) {
+    SetLogger(log.New(w, "", log.LstdFlags))
+}
+
+// tracing reports whether log output is not being discarded.
+func tracing() bool {
+    return atomic.LoadInt32(&traced) == 1
+}
+
+// SetLogger replaces the logger used for tracing, so that the prefix,
+// flags & destination can be configured by the application.
+// A nil logger turns logging off.
+func SetLogger(l *log.Logger) {
+    if l == nil {
+        l = log.New(ioutil.Discard, "", log.LstdFlags)
+    }
     lock.Lock()
     defer lock.Unlock()
-    logger = log.New(w, "", log.LstdFlags)
+    logger = l
+    if l.Writer() != ioutil.Discard {
+        atomic.StoreInt32(&traced, 1)
+    } else {
+        atomic.StoreInt32(&traced, 0)
+    }
+}
+
+// WithDistance supplies the Distance used by GetClosest to choose
+// between the floor & the ceiling of a missing key.
+func WithDistance(d Distance) Option {
+    return func(t *Tree) {
+        t.distance = d
+    }
+}
+
+// WithCapacity preallocates storage for `hint` nodes in a single block,
+// saving an allocation per Put until the block is used up. The hint is
+// advisory: the tree grows past it as needed, & a partially used block
+// stays in memory for as long as any of its nodes is in the tree.
+func WithCapacity(hint int) Option {
+    return func(t *Tree) {
+        if hint > 0 {
+            t.spare = make([]Node, hint)
+        }
+    }
+}
+
+// NewTreeWithCapacity returns an empty Tree with a supplied `Comparator`
+// & room for about `hint` entries. See WithCapacity.
+func NewTreeWithCapacity(c Comparator, hint int) *Tree {
+    return NewTreeWith(c, WithCapacity(hint))
+}
+
+// WithOnOverwrite registers a hook which Put calls whenever it replaces
+// the payload of an existing key, e.g. to release the old payload.
+// The hook runs before the payload is replaced.
+func WithOnOverwrite(hook func(key, oldValue, newValue interface{})) Option {
+    return func(t *Tree) {
+        t.onOverwrite = hook
+    }
+}
+
+// RejectNilValue makes Put fail with ErrorNilValue when the payload is nil.
+// A Get returning (true, nil) is then impossible, so a nil payload never
+// has to be told apart from an absent key. By default nil payloads are
+// allowed.
+func RejectNilValue() Option {
+    return func(t *Tree) {
+        t.rejectNil = true
+    }
+}
+
+// OpCounters holds the cumulative number of operations applied to a tree,
+// see WithOpCounters.
+type OpCounters struct {
+    Inserts    uint64 // Put of a new key
+    Overwrites uint64 // Put of an existing key
+    Deletes    uint64 // Delete of an existing key
+    Rotations  uint64 // left & right rotations, including those of fixups
+}
+
+// WithOpCounters turns on the counting of inserts, overwrites, deletes
+// & rotations over the lifetime of the tree (see OpCounters). It is off
+// by default to spare the bookkeeping.
+func WithOpCounters() Option {
+    return func(t *Tree) {
+        t.counters = &OpCounters{}
+    }
+}
+
+// WithInsertionSeq stamps every node put into the tree with a monotonically
+// increasing sequence number (see Node.Seq, Oldest & Newest), e.g. to evict
+// the oldest inserted key. When `refreshOnOverwrite` is true, overwriting
+// the payload of a key stamps it anew, as if it was inserted again;
+// otherwise the key keeps the sequence number of its first insertion.
+func WithInsertionSeq(refreshOnOverwrite bool) Option {
+    return func(t *Tree) {
+        t.seqOn = true
+        t.seqRefresh = refreshOnOverwrite
+    }
+}
+
+// KeyTransformer derives from a key the form that is compared.
+type KeyTransformer func(key interface{}) interface{}
+
+// WithKeyTransformer makes the comparator see `kt(key)` instead of each key,
+// e.g. a lowercased string to ignore case. The transform is only used for
+// comparing: the key stored, & returned by Keys, Min, Walk etc, is the
+// original key as first put. Putting another key with the same compared
+// form overwrites the payload but keeps the original key. To store the
+// transformed key instead, apply the transform before calling Put.
+func WithKeyTransformer(kt KeyTransformer) Option {
+    return func(t *Tree) {
+        t.compareForm = kt
+        if t.cmp != nil {
+            t.cmp = ComparatorByField(kt, t.cmp)
+        }
+        if t.primary != nil {
+            t.primary = ComparatorByField(kt, t.primary)
+        }
+    }
+}
+
+// WithAccessTracking makes Get & Put record on the node they access an
+// increasing recency stamp, so that LeastRecentlyUsed can tell which entry
+// to evict when the tree serves as an LRU store. Other lookups, such as
+// Has or Walk, do not count as accesses.
+func WithAccessTracking() Option {
+    return func(t *Tree) {
+        t.trackAccess = true
+    }
+}
+
+// WithSameKeyKind makes Put fail with ErrorKeyKindMismatch on a key whose
+// reflect.Kind differs from the kind of the keys already stored, e.g. a
+// `string` key in a tree of `int` keys, instead of letting the comparator
+// panic deep inside the descent. See KeyKind.
+func WithSameKeyKind() Option {
+    return func(t *Tree) {
+        t.sameKind = true
+    }
+}
+
+// WithComparisonCount is a debug mode counting how many times the
+// comparator is called to locate a key, see LastComparisonCount.
+func WithComparisonCount() Option {
+    return func(t *Tree) {
+        t.countCmp = true
+    }
 }
 
 // NewTree returns an empty Tree with default comparator `IntComparator`.
 // `IntComparator` expects keys to be type-assertable to `int`.
-func NewTree() *Tree {
-    return &Tree{root: nil, cmp: IntComparator}
+func NewTree(options ...Option) *Tree {
+    t := NewTreeWith(IntComparator, options...)
+    t.nameComparator("int")
+    return t
+}
+
+// NewTreeNamed returns an empty Tree ordered by the comparator registered
+// under `name` (see RegisterComparator), which the tree remembers for
+// GoLiteral & Tree.ComparatorName. It fails with ErrorUnknownComparator
+// when no comparator is registered under `name`.
+func NewTreeNamed(name string, options ...Option) (*Tree, error) {
+    c, ok := LookupComparator(name)
+    if !ok {
+        return nil, ErrorUnknownComparator
+    }
+    t := NewTreeWith(c, options...)
+    t.nameComparator(name)
+    return t, nil
+}
+
+// nameComparator records the registered name of the comparator, unless
+// an option such as WithKeyTransformer wrapped it into another ordering.
+func (t *Tree) nameComparator(name string) {
+    if t.compareForm == nil {
+        t.cmpName = name
+    }
+}
+
+// ComparatorName returns the name of the comparator ordering the tree, as
+// given to NewTreeNamed, or "int" for NewTree, e.g. to be saved along with
+// the tree & passed to LookupComparator on restore. It returns false for a
+// tree created with an unnamed comparator.
+func (t *Tree) ComparatorName() (string, bool) {
+    return t.cmpName, t.cmpName != ""
 }
 
 // NewTreeWith returns an empty Tree with a supplied `Comparator`.
-func NewTreeWith(c Comparator) *Tree {
-    return &Tree{root: nil, cmp: c}
+func NewTreeWith(c Comparator, options ...Option) *Tree {
+    t := &Tree{cmp: c}
+    t.root = t.leaf()
+    for _, option := range options {
+        option(t)
+    }
+    return t
+}
+
+// NewTreeAuto returns an empty Tree whose comparator is chosen by the
+// first Put from the type of its key: `int` selects IntComparator, `string`
+// StringComparator & `float64` Float64Comparator. The choice is then locked
+// in, so every later key must be of the same type. A first key of any
+// other type, including named types, fails with ErrorKeyTypeUnsupported
+// & leaves the comparator unchosen.
+func NewTreeAuto(options ...Option) *Tree {
+    t := NewTreeWith(nil, options...)
+    t.auto = true
+    return t
+}
+
+// autoComparator returns the comparator a tree created by NewTreeAuto
+// chooses for its first key, `key`, without choosing it yet.
+func (t *Tree) autoComparator(key interface{}) (Comparator, error) {
+    probe := key
+    if t.compareForm != nil {
+        probe = t.compareForm(key)
+    }
+    c, err := comparatorFor(probe)
+    if err != nil {
+        return nil, err
+    }
+    if t.compareForm != nil {
+        return ComparatorByField(t.compareForm, c), nil
+    }
+    return c, nil
+}
+
+// comparatorFor picks the built-in comparator for the type of `key`.
+func comparatorFor(key interface{}) (Comparator, error) {
+    switch key.(type) {
+    case int:
+        return IntComparator, nil
+    case string:
+        return StringComparator, nil
+    case float64:
+        return Float64Comparator, nil
+    default:
+        return nil, ErrorKeyTypeUnsupported
+    }
+}
+
+// NewTreeWith2 returns an empty Tree ordering keys by `primary`, falling
+// back on `tiebreak` for keys which `primary` considers equal. Keys only
+// collide, & thus overwrite each other, when both comparators return 0.
+// Without a tiebreak (nil), equal keys by `primary` collide as with
+// NewTreeWith.
+func NewTreeWith2(primary, tiebreak Comparator, options ...Option) *Tree {
+    if tiebreak == nil {
+        return NewTreeWith(primary, options...)
+    }
+    withPrimary := func(t *Tree) {
+        t.primary = primary
+    }
+    return NewTreeWith(func(o1, o2 interface{}) int {
+        if c := primary(o1, o2); c != 0 {
+            return c
+        }
+        return tiebreak(o1, o2)
+    }, append([]Option{withPrimary}, options...)...)
+}
+
+// leaf returns the sentinel of the tree. Trees built from a literal
+// start without one, so it is created on first use.
+func (t *Tree) leaf() *Node {
+    if t.sentinel == nil {
+        t.sentinel = &Node{color: BLACK}
+    }
+    return t.sentinel
+}
+
+// newNode hands out a preallocated node when one is left.
+func (t *Tree) newNode(key interface{}, payload interface{}, color Color, parent *Node) *Node {
+    var n *Node
+    if len(t.spare) > 0 {
+        n, t.spare = &t.spare[0], t.spare[1:]
+    } else {
+        n = &Node{}
+    }
+    leaf := t.leaf()
+    n.key, n.payload, n.color = key, payload, color
+    n.left, n.right, n.parent = leaf, leaf, parent
+    return n
+}
+
+// leafIfNil substitutes the sentinel for a nil pointer.
+func (t *Tree) leafIfNil(n *Node) *Node {
+    if n == nil {
+        return t.leaf()
+    }
+    return n
 }
 
 // Get looks for the node with supplied key and returns its mapped payload.
@@ -186,29 +685,86 @@ func (t *Tree) Get(key interface{}) (bool, interface{}) {
 
     ok, node := t.getNode(key)
     if ok {
+        t.touch(node)
         return true, node.payload
     } else {
         return false, nil
     }
 }
 
-func (t *Tree) getNode(key interface{}) (bool, *Node) {
-    found, parent, dir := t.GetParent(key)
-    if found {
-        if parent == nil {
-            return true, t.root
-        } else {
-            var node *Node
-            switch dir {
-            case LEFT:
-                node = parent.left
-            case RIGHT:
-                node = parent.right
-            }
+// MultiGet looks up each of `keys` & returns the entries of those found,
+// in the order of `keys`. Missing & invalid keys are left out, so the
+// result may be shorter than `keys`; a key given twice appears twice.
+func (t *Tree) MultiGet(keys []interface{}) []Entry {
+    entries := []Entry{}
+    for _, key := range keys {
+        if ok, node := t.getNode(key); ok {
+            entries = append(entries, Entry{Key: node.key, Value: node.payload})
+        }
+    }
+    return entries
+}
 
-            if node != nil {
-                return true, node
-            }
+// SafeGet is like Get, except that a panic of the comparator, typically a
+// failed type assertion on a key of the wrong type, is recovered & reported
+// as ErrorKeyIncomparable. A non-nil error thus means that `key` could not
+// be compared with the stored keys, not that it is absent.
+func (t *Tree) SafeGet(key interface{}) (value interface{}, ok bool, err error) {
+    if err := mustBeValidKey(key); err != nil {
+        return nil, false, err
+    }
+    defer func() {
+        if r := recover(); r != nil {
+            logger.Printf("SafeGet recovered from comparator panic: %v\n", r)
+            value, ok, err = nil, false, ErrorKeyIncomparable
+        }
+    }()
+
+    ok, value = t.Get(key)
+    return value, ok, nil
+}
+
+// GetDetailed is a diagnostic variant of Get. Besides the payload, it
+// reports the color of the node holding `key` and its depth, where the
+// root is at depth 0. When `key` is absent, ok is false.
+func (t *Tree) GetDetailed(key interface{}) (value interface{}, color Color, depth int, ok bool) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("GetDetailed was prematurely aborted: %s\n", err.Error())
+        return nil, BLACK, 0, false
+    }
+
+    for n := t.root; !isNil(n); depth++ {
+        switch c := t.cmp(key, n.key); {
+        case c < 0:
+            n = n.left
+        case c > 0:
+            n = n.right
+        default:
+            return n.payload, n.color, depth, true
+        }
+    }
+    return nil, BLACK, 0, false
+}
+
+// getNode descends once from the root to the node of `key`, comparing
+// `key` once per node on the way.
+func (t *Tree) getNode(key interface{}) (bool, *Node) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("getNode was prematurely aborted: %s\n", err.Error())
+        return false, nil
+    }
+    t.resetComparisons()
+    for n := t.root; !isNil(n); {
+        if t.countCmp {
+            t.comparisons++
+        }
+        switch c := t.cmp(key, n.key); {
+        case c < 0:
+            n = n.left
+        case c > 0:
+            n = n.right
+        default:
+            return true, n
         }
     }
     return false, nil
@@ -218,7 +774,7 @@ func (t *Tree) getNode(key interface{}) (bool, *Node) {
 // at the subtree rooted at node x. Assume x is not nil.
 func (t *Tree) getMinimum(x *Node) *Node {
     for {
-        if x.left != nil {
+        if !isNil(x.left) {
             x = x.left
         } else {
             return x
@@ -226,6 +782,18 @@ func (t *Tree) getMinimum(x *Node) *Node {
     }
 }
 
+// getMaximum returns the node with maximum key starting
+// at the subtree rooted at node x. Assume x is not nil.
+func (t *Tree) getMaximum(x *Node) *Node {
+    for {
+        if !isNil(x.right) {
+            x = x.right
+        } else {
+            return x
+        }
+    }
+}
+
 // GetParent looks for the node with supplied key and returns the parent node.
 func (t *Tree) GetParent(key interface{}) (found bool, parent *Node, dir Direction) {
     if err := mustBeValidKey(key); err != nil {
@@ -233,46 +801,91 @@ func (t *Tree) GetParent(key interface{}) (found bool, parent *Node, dir Directi
         return false, nil, NODIR
     }
 
-    if t.root == nil {
+    if isNil(t.root) {
+        t.resetComparisons()
         return false, nil, NODIR
     }
 
+    return t.locate(key)
+}
+
+// RootSide compares `key` with the key of the root only & tells on which
+// side of the root it belongs: LEFT when smaller, RIGHT when greater &
+// NODIR when equal. It returns false on an empty tree. The answer reflects
+// the current root, which changes as the tree rebalances on Put & Delete.
+func (t *Tree) RootSide(key interface{}) (Direction, bool) {
+    if err := mustBeValidKey(key); err != nil {
+        logger.Printf("RootSide was prematurely aborted: %s\n", err.Error())
+        return NODIR, false
+    }
+    if isNil(t.root) {
+        return NODIR, false
+    }
+    switch c := t.cmp(key, t.root.key); {
+    case c < 0:
+        return LEFT, true
+    case c > 0:
+        return RIGHT, true
+    default:
+        return NODIR, true
+    }
+}
+
+// locate searches for `key` from the root, see internalLookup.
+func (t *Tree) locate(key interface{}) (bool, *Node, Direction) {
+    t.resetComparisons()
     return t.internalLookup(nil, t.root, key, NODIR)
 }
 
+// resetComparisons starts the count of a new lookup. The tree is only
+// written to when counting is on, so that concurrent readers stay safe.
+func (t *Tree) resetComparisons() {
+    if t.countCmp {
+        t.comparisons = 0
+    }
+}
+
+// internalLookup compares `key` once per node, so that a comparator
+// giving different answers on repeated calls cannot send the descent
+// one way & the caller another.
 func (t *Tree) internalLookup(parent *Node, this *Node, key interface{}, dir Direction) (bool, *Node, Direction) {
-    switch {
-    case this == nil:
+    if isNil(this) {
         return false, parent, dir
-    case t.cmp(key, this.key) == 0:
+    }
+    if t.countCmp {
+        t.comparisons++
+    }
+    switch c := t.cmp(key, this.key); {
+    case c == 0:
         return true, parent, dir
-    case t.cmp(key, this.key) < 0:
+    case c < 0:
         return t.internalLookup(this, this.left, key, LEFT)
-    case t.cmp(key, this.key) > 0:
-        return t.internalLookup(this, this.right, key, RIGHT)
     default:
-        return false, parent, NODIR
+        return t.internalLookup(this, this.right, key, RIGHT)
     }
 }
 
 // Reverses actions of RotateLeft
 func (t *Tree) RotateRight(y *Node) {
-    if y == nil {
+    if isNil(y) {
         logger.Printf("RotateRight: nil arg cannot be rotated. Noop\n")
         return
     }
-    if y.left == nil {
+    if isNil(y.left) {
         logger.Printf("RotateRight: y has nil left subtree. Noop\n")
         return
     }
     logger.Printf("\t\t\trotate right of %s\n", y)
+    if t.counters != nil {
+        t.counters.Rotations++
+    }
     x := y.left
     y.left = x.right
-    if x.right != nil {
+    if !isNil(x.right) {
         x.right.parent = y
     }
     x.parent = y.parent
-    if y.parent == nil {
+    if isNil(y.parent) {
         t.root = x
     } else {
         if y == y.parent.left {
@@ -287,23 +900,26 @@ func (t *Tree) RotateRight(y *Node) {
 
 // Side-effect: red-black tree properties is maintained.
 func (t *Tree) RotateLeft(x *Node) {
-    if x == nil {
+    if isNil(x) {
         logger.Printf("RotateLeft: nil arg cannot be rotated. Noop\n")
         return
     }
-    if x.right == nil {
+    if isNil(x.right) {
         logger.Printf("RotateLeft: x has nil right subtree. Noop\n")
         return
     }
     logger.Printf("\t\t\trotate left of %s\n", x)
+    if t.counters != nil {
+        t.counters.Rotations++
+    }
 
     y := x.right
     x.right = y.left
-    if y.left != nil {
+    if !isNil(y.left) {
         y.left.parent = x
     }
     y.parent = x.parent
-    if x.parent == nil {
+    if isNil(x.parent) {
         t.root = y
     } else {
         if x == x.parent.left {
@@ -317,38 +933,110 @@ func (t *Tree) RotateLeft(x *Node) {
 }
 
 // Put saves the mapping (key, data) into the tree.
-// If a mapping identified by `key` already exists, it is overwritten.
+// If a mapping identified by `key` already exists, it is overwritten
+// (see WithOnOverwrite).
 // Constraint: Not everything can be a key.
 func (t *Tree) Put(key interface{}, data interface{}) error {
+    _, err := t.put(key, data, nil)
+    return err
+}
+
+// PutIf saves the mapping (key, data) like Put, but only when `cond`
+// approves, & reports whether it did. `cond` is called once with the
+// current payload of `key` & true when `key` exists, or with nil & false
+// when it would be inserted; the node is looked up once. An invalid key
+// or payload (see Put) is never saved & `cond` is then not called.
+func (t *Tree) PutIf(key, data interface{}, cond func(old interface{}, existed bool) bool) bool {
+    written, _ := t.put(key, data, cond)
+    return written
+}
+
+// put implements Put & PutIf; a nil `cond` approves of every write.
+func (t *Tree) put(key interface{}, data interface{}, cond func(old interface{}, existed bool) bool) (bool, error) {
+    t.startTrace()
     if err := mustBeValidKey(key); err != nil {
         logger.Printf("Put was prematurely aborted: %s\n", err.Error())
-        return err
+        return false, err
+    }
+    if data == nil && t.rejectNil {
+        logger.Printf("Put was prematurely aborted: %s\n", ErrorNilValue.Error())
+        return false, ErrorNilValue
+    }
+    if t.cmp == nil && t.auto {
+        c, err := t.autoComparator(key)
+        if err != nil {
+            logger.Printf("Put was prematurely aborted: %s\n", err.Error())
+            return false, err
+        }
+        t.cmp = c
+    }
+    if t.sameKind && !isNil(t.root) && reflect.ValueOf(key).Kind() != reflect.ValueOf(t.root.key).Kind() {
+        logger.Printf("Put was prematurely aborted: %s\n", ErrorKeyKindMismatch.Error())
+        return false, ErrorKeyKindMismatch
+    }
+    if t.checkCmp {
+        t.mustBeConsistent(key)
     }
 
-    if t.root == nil {
-        t.root = &Node{key: key, color: BLACK, payload: data}
+    if isNil(t.root) {
+        t.resetComparisons()
+        if cond != nil && !cond(nil, false) {
+            return false, nil
+        }
+        t.root = t.newNode(key, data, BLACK, t.leaf())
+        t.stamp(t.root)
+        t.touch(t.root)
+        t.minNode, t.maxNode = t.root, t.root
+        if t.counters != nil {
+            t.counters.Inserts++
+        }
         logger.Printf("Added %s as root node\n", t.root.String())
-        return nil
+        return true, nil
     }
 
-    found, parent, dir := t.internalLookup(nil, t.root, key, NODIR)
+    found, parent, dir := t.locate(key)
     if found {
+        node := t.root
         if parent == nil {
             logger.Printf("Put: parent=nil & found. Overwrite ROOT node\n")
-            t.root.payload = data
         } else {
             logger.Printf("Put: parent!=nil & found. Overwriting\n")
             switch dir {
             case LEFT:
-                parent.left.payload = data
+                node = parent.left
             case RIGHT:
-                parent.right.payload = data
+                node = parent.right
             }
         }
+        if cond != nil && !cond(node.payload, true) {
+            return false, nil
+        }
+        if t.onOverwrite != nil {
+            t.onOverwrite(key, node.payload, data)
+        }
+        node.payload = data
+        if t.seqRefresh {
+            t.stamp(node)
+        }
+        t.touch(node)
+        if t.counters != nil {
+            t.counters.Overwrites++
+        }
 
     } else {
         if parent != nil {
-            newNode := &Node{key: key, parent: parent, payload: data}
+            if cond != nil && !cond(nil, false) {
+                return false, nil
+            }
+            newNode := t.newNode(key, data, RED, parent)
+            t.stamp(newNode)
+            t.touch(newNode)
+            if dir == LEFT && parent == t.minNode {
+                t.minNode = newNode
+            }
+            if dir == RIGHT && parent == t.maxNode {
+                t.maxNode = newNode
+            }
             switch dir {
             case LEFT:
                 parent.left = newNode
@@ -356,10 +1044,29 @@ func (t *Tree) Put(key interface{}, data interface{}) error {
                 parent.right = newNode
             }
             logger.Printf("Added %s to %s node of parent %s\n", newNode.String(), dir, parent.String())
+            if t.counters != nil {
+                t.counters.Inserts++
+            }
             t.fixupPut(newNode)
         }
     }
-    return nil
+    return true, nil
+}
+
+// touch gives n the next recency stamp, if enabled.
+func (t *Tree) touch(n *Node) {
+    if t.trackAccess {
+        t.lastAccess++
+        n.metadata().access = t.lastAccess
+    }
+}
+
+// stamp gives n the next insertion sequence number, if enabled.
+func (t *Tree) stamp(n *Node) {
+    if t.seqOn {
+        t.lastSeq++
+        n.metadata().seq = t.lastSeq
+    }
 }
 
 func isRed(n *Node) bool {
@@ -386,7 +1093,7 @@ loop:
     for {
         logger.Printf("\tcurrent z %s\n", z.String())
         switch {
-        case z.parent == nil:
+        case isNil(z.parent):
             fallthrough
         case z.parent.color == BLACK:
             fallthrough
@@ -396,7 +1103,7 @@ loop:
             break loop
         case z.parent.color == RED:
             grandparent := z.parent.parent
-            logger.Printf("\t\tgrandparent is nil %t\n", grandparent == nil)
+            logger.Printf("\t\tgrandparent is nil %t\n", isNil(grandparent))
             if z.parent == grandparent.left {
                 logger.Printf("\t\t%s is the left child of %s\n", z.parent, grandparent)
                 y := grandparent.right
@@ -404,6 +1111,7 @@ loop:
                 if isRed(y) {
                     // case 1 - y is RED
                     logger.Printf("\t\t(*) case 1\n")
+                    t.traceFixup("put", 1, z)
                     z.parent.color = BLACK
                     y.color = BLACK
                     grandparent.color = RED
@@ -413,12 +1121,14 @@ loop:
                     if z == z.parent.right {
                         // case 2
                         logger.Printf("\t\t(*) case 2\n")
+                        t.traceFixup("put", 2, z)
                         z = z.parent
                         t.RotateLeft(z)
                     }
 
                     // case 3
                     logger.Printf("\t\t(*) case 3\n")
+                    t.traceFixup("put", 3, z)
                     z.parent.color = BLACK
                     grandparent.color = RED
                     t.RotateRight(grandparent)
@@ -430,6 +1140,7 @@ loop:
                 if isRed(y) {
                     // case 1 - y is RED
                     logger.Printf("\t\t..(*) case 1\n")
+                    t.traceFixup("put", 1, z)
                     z.parent.color = BLACK
                     y.color = BLACK
                     grandparent.color = RED
@@ -440,12 +1151,14 @@ loop:
                     if z == z.parent.left {
                         // case 2
                         logger.Printf("\t\t..(*) case 2\n")
+                        t.traceFixup("put", 2, z)
                         z = z.parent
                         t.RotateRight(z)
                     }
 
                     // case 3
                     logger.Printf("\t\t..(*) case 3\n")
+                    t.traceFixup("put", 3, z)
                     z.parent.color = BLACK
                     grandparent.color = RED
                     t.RotateLeft(grandparent)
@@ -456,6 +1169,62 @@ loop:
     t.root.color = BLACK
 }
 
+// startTrace empties the fixup trace at the start of a Put or Delete. The
+// trace is only made non-nil while tracing is on, so that traceFixup need
+// not check again for every case.
+func (t *Tree) startTrace() {
+    t.fixupTrace = nil
+    if tracing() {
+        t.fixupTrace = []string{}
+    }
+}
+
+// traceFixup records that fixup case `c` of operation `op` was
+// applied at node n. Nothing is recorded unless tracing is on.
+func (t *Tree) traceFixup(op string, c int, n *Node) {
+    if t.fixupTrace != nil {
+        t.fixupTrace = append(t.fixupTrace, fmt.Sprintf("%s case %d at %v", op, c, n.key))
+    }
+}
+
+// LastFixupTrace lists, in order, the fixup cases applied during the most
+// recent Put or Delete, each with the key of the node being fixed up.
+// The sentinel leaf shows up as <nil>. The list is only populated while
+// tracing is on (see TraceOn & SetOutput); otherwise it is empty.
+func (t *Tree) LastFixupTrace() []string {
+    trace := make([]string, len(t.fixupTrace))
+    copy(trace, t.fixupTrace)
+    return trace
+}
+
+// LastComparisonCount returns how many times the comparator was called
+// to locate the key of the most recent Put, Get, Has or Delete, or of the
+// variants of these such as PutIf. Comparisons made by WithComparatorCheck
+// are left out. It is always 0 unless the tree was created with
+// WithComparisonCount.
+func (t *Tree) LastComparisonCount() int {
+    return t.comparisons
+}
+
+// KeyKind returns the reflect.Kind shared by all keys of the tree. It
+// returns false when the tree is empty or its keys are of mixed kinds.
+// It visits every key, unless the kinds differ early.
+func (t *Tree) KeyKind() (reflect.Kind, bool) {
+    kind, mixed := reflect.Invalid, false
+    inorder(t.root, func(n *Node) bool {
+        k := reflect.ValueOf(n.key).Kind()
+        if kind == reflect.Invalid {
+            kind = k
+        }
+        mixed = k != kind
+        return !mixed
+    })
+    if kind == reflect.Invalid || mixed {
+        return reflect.Invalid, false
+    }
+    return kind, true
+}
+
 // Size returns the number of items in the tree.
 func (t *Tree) Size() uint64 {
     visitor := &countingVisitor{}
@@ -463,55 +1232,142 @@ func (t *Tree) Size() uint64 {
     return visitor.Count
 }
 
+// nodeOverheadBytes approximates the memory taken by a single Node
+// struct. It excludes whatever the key & payload interfaces point to,
+// as well as any allocator bookkeeping.
+const nodeOverheadBytes = uint64(unsafe.Sizeof(Node{}))
+
+// nodeMetaBytes is the extra memory of a node with bookkeeping.
+const nodeMetaBytes = uint64(unsafe.Sizeof(nodeMeta{}))
+
+// ApproxMemoryBytes estimates the memory held by the tree: the node
+// overhead times the number of nodes plus the sum of `valueSizer(payload)`
+// over all nodes. A nil `valueSizer` counts only the node overhead.
+// The figure is a rough guide for capacity planning, not an exact measure.
+func (t *Tree) ApproxMemoryBytes(valueSizer func(interface{}) int) uint64 {
+    visitor := &sizingVisitor{valueSizer: valueSizer}
+    t.Walk(visitor)
+    return visitor.Bytes
+}
+
+// ShrinkToFit releases memory held by the tree beyond its nodes: the
+// buffer behind LastFixupTrace, the unused nodes preallocated through
+// WithCapacity & the parent link left on the sentinel by the last Delete,
+// which could keep a removed node reachable.
+// The content of the tree is unchanged.
+func (t *Tree) ShrinkToFit() {
+    t.fixupTrace = nil
+    t.spare = nil
+    if t.sentinel != nil {
+        t.sentinel.parent = nil
+    }
+}
+
+// OpCounters returns the operations counted since the tree was created or
+// since the last ResetCounters. All counters stay at zero unless the tree
+// was created with WithOpCounters.
+func (t *Tree) OpCounters() OpCounters {
+    if t.counters == nil {
+        return OpCounters{}
+    }
+    return *t.counters
+}
+
+// ResetCounters sets all operation counters back to zero.
+func (t *Tree) ResetCounters() {
+    if t.counters != nil {
+        *t.counters = OpCounters{}
+    }
+}
+
 // Has checks for existence of a item identified by supplied key.
 func (t *Tree) Has(key interface{}) bool {
     if err := mustBeValidKey(key); err != nil {
         logger.Printf("Has was prematurely aborted: %s\n", err.Error())
         return false
     }
-    found, _, _ := t.internalLookup(nil, t.root, key, NODIR)
+    found, _, _ := t.locate(key)
     return found
 }
 
+// transplant replaces the subtree rooted at u with the subtree rooted at v.
+// As in CLRS, v's parent is assigned even when v is the sentinel.
 func (t *Tree) transplant(u *Node, v *Node) {
-    if u.parent == nil {
+    if isNil(u.parent) {
         t.root = v
     } else if u == u.parent.left {
         u.parent.left = v
     } else {
         u.parent.right = v
     }
-    if v != nil && u != nil {
-        v.parent = u.parent
-    }
+    v.parent = u.parent
 }
 
 // Delete removes the item identified by the supplied key.
 // Delete is a noop if the supplied key doesn't exist.
 func (t *Tree) Delete(key interface{}) {
-    if !t.Has(key) {
+    t.startTrace()
+    // a single lookup, as a second one might disagree with the first
+    found, z := t.getNode(key)
+    if !found {
         logger.Printf("Delete: bail as no node exists for key %d\n", key)
         return
     }
-    _, z := t.getNode(key)
+    t.deleteNode(z)
+}
+
+// DeleteIf removes the item identified by `key` only if `cond` approves
+// of its current payload, & reports whether it was removed. The node is
+// looked up once. When `key` is absent, it returns false without calling
+// `cond`.
+func (t *Tree) DeleteIf(key interface{}, cond func(value interface{}) bool) bool {
+    t.startTrace()
+    found, z := t.getNode(key)
+    if !found || !cond(z.payload) {
+        return false
+    }
+    t.deleteNode(z)
+    return true
+}
+
+// GetAndDelete removes the item identified by `key` & returns its
+// payload, looking the node up once, e.g. to consume a task. When `key`
+// is absent, it returns nil & false & the tree is unchanged.
+func (t *Tree) GetAndDelete(key interface{}) (interface{}, bool) {
+    t.startTrace()
+    found, z := t.getNode(key)
+    if !found {
+        return nil, false
+    }
+    payload := z.payload
+    t.deleteNode(z)
+    return payload, true
+}
+
+// deleteNode removes z, a node of the tree, & rebalances.
+func (t *Tree) deleteNode(z *Node) {
     logger.Printf("Delete: attempt to delete %s\n", z)
+    if t.counters != nil {
+        t.counters.Deletes++
+    }
+    wasExtreme := z == t.minNode || z == t.maxNode
     y := z
     yOriginalColor := y.color
     var x *Node
 
-    if z.left == nil {
+    if isNil(z.left) {
         // one child (RIGHT)
         logger.Printf("\t\tDelete: case (a)\n")
-        x = z.right
+        x = t.leafIfNil(z.right)
         logger.Printf("\t\t\t--- x is right of z")
-        t.transplant(z, z.right)
+        t.transplant(z, x)
 
-    } else if z.right == nil {
+    } else if isNil(z.right) {
         // one child (LEFT)
         logger.Printf("\t\tDelete: case (b)\n")
-        x = z.left
+        x = t.leafIfNil(z.left)
         logger.Printf("\t\t\t--- x is left of z")
-        t.transplant(z, z.left)
+        t.transplant(z, x)
 
     } else {
         // two children
@@ -519,15 +1375,13 @@ func (t *Tree) Delete(key interface{}) {
         y = t.getMinimum(z.right)
         logger.Printf("\t\t\tminimum of z.right is %s (color=%s)\n", y, y.color)
         yOriginalColor = y.color
-        x = y.right
+        x = t.leafIfNil(y.right)
         logger.Printf("\t\t\t--- x is right of minimum")
 
         if y.parent == z {
-            if x != nil {
-                x.parent = y
-            }
+            x.parent = y
         } else {
-            t.transplant(y, y.right)
+            t.transplant(y, x)
             y.right = z.right
             y.right.parent = y
         }
@@ -539,95 +1393,105 @@ func (t *Tree) Delete(key interface{}) {
     if yOriginalColor == BLACK {
         t.fixupDelete(x)
     }
+    if wasExtreme {
+        t.refreshExtremes()
+    }
 }
 
+// fixupDelete restores the red-black properties after a black node
+// was spliced out. `x` carries the extra black; it may be the sentinel,
+// whose parent was set by `transplant`.
+//
+// Preconditions:
+// P1) x is not a nil pointer
 func (t *Tree) fixupDelete(x *Node) {
     logger.Printf("\t\t\tfixupDelete of node %s\n", x)
-    if x == nil {
-        return
-    }
-loop:
-    for {
-        switch {
-        case x == t.root:
-            logger.Printf("\t\t\t=> bye .. is root\n")
-            break loop
-        case x.color == RED:
-            logger.Printf("\t\t\t=> bye .. RED\n")
-            break loop
-        case x == x.parent.right:
-            logger.Printf("\t\tBRANCH: x is right child of parent\n")
-            w := x.parent.left // is nillable
-            if isRed(w) {
-                // Convert case 1 into case 2, 3, or 4
-                logger.Printf("\t\t\tR> case 1\n")
-                w.color = BLACK
-                x.parent.color = RED
-                t.RotateRight(x.parent)
-                w = x.parent.left
-            }
-            if w != nil {
-                switch {
-                case !isRed(w.left) && !isRed(w.right):
-                    // case 2 - both children of w are BLACK
-                    logger.Printf("\t\t\tR> case 2\n")
-                    w.color = RED
-                    x = x.parent // recurse up tree
-                case isRed(w.right) && !isRed(w.left):
-                    // case 3 - right child RED & left child BLACK
-                    // convert to case 4
-                    logger.Printf("\t\t\tR> case 3\n")
-                    w.right.color = BLACK
-                    w.color = RED
-                    t.RotateLeft(w)
-                    w = x.parent.left
-                }
-                if isRed(w.left) {
-                    // case 4 - left child is RED
-                    logger.Printf("\t\t\tR> case 4\n")
-                    w.color = x.parent.color
-                    x.parent.color = BLACK
-                    w.left.color = BLACK
-                    t.RotateRight(x.parent)
-                    x = t.root
-                }
-            }
-        case x == x.parent.left:
+    for x != t.root && !isRed(x) {
+        if x == x.parent.left {
             logger.Printf("\t\tBRANCH: x is left child of parent\n")
-            w := x.parent.right // is nillable
+            w := x.parent.right
             if isRed(w) {
-                // Convert case 1 into case 2, 3, or 4
+                // case 1 - convert into case 2, 3, or 4
                 logger.Printf("\t\t\tL> case 1\n")
+                t.traceFixup("delete", 1, x)
                 w.color = BLACK
                 x.parent.color = RED
                 t.RotateLeft(x.parent)
                 w = x.parent.right
             }
-            if w != nil {
-                switch {
-                case !isRed(w.left) && !isRed(w.right):
-                    // case 2 - both children of w are BLACK
-                    logger.Printf("\t\t\tL> case 2\n")
-                    w.color = RED
-                    x = x.parent // recurse up tree
-                case isRed(w.left) && !isRed(w.right):
+            if isNil(w) {
+                // no sibling, only in a tree built unbalanced, e.g. by hand
+                x = x.parent
+                continue
+            }
+            if !isRed(w.left) && !isRed(w.right) {
+                // case 2 - both children of w are BLACK
+                logger.Printf("\t\t\tL> case 2\n")
+                t.traceFixup("delete", 2, x)
+                w.color = RED
+                x = x.parent // recurse up tree
+            } else {
+                if !isRed(w.right) {
                     // case 3 - left child RED & right child BLACK
                     // convert to case 4
                     logger.Printf("\t\t\tL> case 3\n")
+                    t.traceFixup("delete", 3, x)
                     w.left.color = BLACK
                     w.color = RED
                     t.RotateRight(w)
                     w = x.parent.right
                 }
-                if isRed(w.right) {
-                    // case 4 - right child is RED
-                    logger.Printf("\t\t\tL> case 4\n")
-                    w.color = x.parent.color
-                    x.parent.color = BLACK
+                // case 4 - right child is RED
+                logger.Printf("\t\t\tL> case 4\n")
+                t.traceFixup("delete", 4, x)
+                w.color = x.parent.color
+                x.parent.color = BLACK
+                w.right.color = BLACK
+                t.RotateLeft(x.parent)
+                x = t.root
+            }
+        } else {
+            logger.Printf("\t\tBRANCH: x is right child of parent\n")
+            w := x.parent.left
+            if isRed(w) {
+                // case 1 - convert into case 2, 3, or 4
+                logger.Printf("\t\t\tR> case 1\n")
+                t.traceFixup("delete", 1, x)
+                w.color = BLACK
+                x.parent.color = RED
+                t.RotateRight(x.parent)
+                w = x.parent.left
+            }
+            if isNil(w) {
+                // no sibling, only in a tree built unbalanced, e.g. by hand
+                x = x.parent
+                continue
+            }
+            if !isRed(w.left) && !isRed(w.right) {
+                // case 2 - both children of w are BLACK
+                logger.Printf("\t\t\tR> case 2\n")
+                t.traceFixup("delete", 2, x)
+                w.color = RED
+                x = x.parent // recurse up tree
+            } else {
+                if !isRed(w.left) {
+                    // case 3 - right child RED & left child BLACK
+                    // convert to case 4
+                    logger.Printf("\t\t\tR> case 3\n")
+                    t.traceFixup("delete", 3, x)
                     w.right.color = BLACK
-                    t.RotateLeft(x.parent)
-                    x = t.root
+                    w.color = RED
+                    t.RotateLeft(w)
+                    w = x.parent.left
                 }
+                // case 4 - left child is RED
+                logger.Printf("\t\t\tR> case 4\n")
+                t.traceFixup("delete", 4, x)
+                w.color = x.parent.color
+                x.parent.color = BLACK
+                w.left.color = BLACK
+                t.RotateRight(x.parent)
+                x = t.root
             }
         }
     }
@@ -635,10 +1499,342 @@ loop:
 }
 
 // Walk accepts a Visitor
+// An empty tree is visited as a nil root.
 func (t *Tree) Walk(visitor Visitor) {
+    if isNil(t.root) {
+        visitor.Visit(nil)
+        return
+    }
     visitor.Visit(t.root)
 }
 
+// WalkSubtree lets `visitor` walk only the subtree rooted at the node
+// holding `key`. It returns false, without visiting, when `key` is absent.
+// Membership of the subtree is structural: it depends on the shape of
+// the tree, which rebalancing changes, & not on any relation between keys.
+func (t *Tree) WalkSubtree(key interface{}, visitor Visitor) bool {
+    ok, node := t.getNode(key)
+    if !ok {
+        return false
+    }
+    visitor.Visit(node)
+    return true
+}
+
+// inorder calls `fn` on each node of the subtree rooted at n in
+// ascending key order. It stops & returns false once `fn` does.
+func inorder(n *Node, fn func(*Node) bool) bool {
+    if isNil(n) {
+        return true
+    }
+    return inorder(n.left, fn) && fn(n) && inorder(n.right, fn)
+}
+
+// ReplaceAll walks the tree in order and replaces the payload of
+// every node with `transform(key, payload)`. Keys are left untouched,
+// so the shape of the tree does not change. When the tree was created
+// with RejectNilValue & `transform` returns nil for any key, the tree is
+// left unchanged.
+func (t *Tree) ReplaceAll(transform func(key, value interface{}) interface{}) {
+    if transform == nil {
+        return
+    }
+    if !t.rejectNil {
+        t.Walk(&replacingVisitor{transform: transform})
+        return
+    }
+    var nodes []*Node
+    var payloads []interface{}
+    inorder(t.root, func(n *Node) bool {
+        nodes = append(nodes, n)
+        payloads = append(payloads, transform(n.key, n.payload))
+        return true
+    })
+    for _, payload := range payloads {
+        if payload == nil {
+            logger.Printf("ReplaceAll was prematurely aborted: %s\n", ErrorNilValue.Error())
+            return
+        }
+    }
+    for i, n := range nodes {
+        n.payload = payloads[i]
+    }
+}
+
+// Rekey replaces every key with `transform(key)` in place, e.g. to shift
+// a time window by an offset, without any rotation or allocation of nodes.
+// The transform must be strictly order-preserving under the comparator:
+// one that is not corrupts the tree, as lookups then take wrong turns.
+// When the tree was created with WithComparatorCheck, the new keys are
+// checked up front & Rekey panics on a transform breaking the order.
+// Should `transform` return an invalid key (see Put), the tree is left
+// unchanged.
+func (t *Tree) Rekey(transform func(interface{}) interface{}) {
+    if transform == nil {
+        return
+    }
+    var nodes []*Node
+    var keys []interface{}
+    inorder(t.root, func(n *Node) bool {
+        nodes = append(nodes, n)
+        keys = append(keys, transform(n.key))
+        return true
+    })
+    for _, key := range keys {
+        if err := mustBeValidKey(key); err != nil {
+            logger.Printf("Rekey was prematurely aborted: %s\n", err.Error())
+            return
+        }
+    }
+    if t.checkCmp && !isStrictlyAscending(t.cmp, keys) {
+        t.comparatorViolation("Rekey transform does not preserve the order of keys")
+    }
+    for i, n := range nodes {
+        n.key = keys[i]
+    }
+}
+
+// Fold combines the entries of the tree into a single value. Starting with
+// `initial`, the accumulator is replaced by `fn(acc, key, value)` for each
+// entry, strictly from left to right in ascending key order, so that
+// order-sensitive folds (e.g. building a string) are deterministic.
+func (t *Tree) Fold(initial interface{}, fn func(acc, key, value interface{}) interface{}) interface{} {
+    acc := initial
+    inorder(t.root, func(n *Node) bool {
+        acc = fn(acc, n.key, n.payload)
+        return true
+    })
+    return acc
+}
+
+// Each calls `fn` on every entry in ascending key order,
+// stopping early when `fn` returns false.
+func (t *Tree) Each(fn func(key, value interface{}) bool) {
+    inorder(t.root, func(n *Node) bool {
+        return fn(n.key, n.payload)
+    })
+}
+
+// Keys returns all keys in ascending order.
+func (t *Tree) Keys() []interface{} {
+    keys := []interface{}{}
+    inorder(t.root, func(n *Node) bool {
+        keys = append(keys, n.key)
+        return true
+    })
+    return keys
+}
+
+// SnapshotKeys returns all keys in ascending order, copied up front, so
+// that the caller may Put & Delete freely while looping over them:
+//
+//    for _, key := range t.SnapshotKeys() {
+//        if stale(key) {
+//            t.Delete(key)
+//        }
+//    }
+//
+// Keys deleted during the loop are still visited; keys added are not.
+// Use Walk, Each or an Iterator instead when the tree is not mutated.
+func (t *Tree) SnapshotKeys() []interface{} {
+    return t.Keys()
+}
+
+// Values returns all payloads in ascending order of their keys.
+func (t *Tree) Values() []interface{} {
+    values := []interface{}{}
+    inorder(t.root, func(n *Node) bool {
+        values = append(values, n.payload)
+        return true
+    })
+    return values
+}
+
+// Min returns the entry with the smallest key in O(1): the node is cached
+// & maintained by Put & Delete. The boolean is false when the tree is empty.
+func (t *Tree) Min() (*Entry, bool) {
+    n := t.minimum()
+    if n == nil {
+        return nil, false
+    }
+    return &Entry{Key: n.key, Value: n.payload}, true
+}
+
+// Max returns the entry with the largest key in O(1), see Min.
+// The boolean is false when the tree is empty.
+func (t *Tree) Max() (*Entry, bool) {
+    n := t.maximum()
+    if n == nil {
+        return nil, false
+    }
+    return &Entry{Key: n.key, Value: n.payload}, true
+}
+
+// minimum returns the node of the least key, or nil on an empty tree.
+// The node is cached on the tree & kept up to date by every write, so
+// that reads never modify the tree. Only a tree assembled by hand lacks
+// the cache, which is then searched for.
+func (t *Tree) minimum() *Node {
+    if t.minNode == nil && !isNil(t.root) {
+        return t.getMinimum(t.root)
+    }
+    return t.minNode
+}
+
+// maximum returns the node of the greatest key, or nil on an empty tree.
+// It is cached like the node returned by minimum.
+func (t *Tree) maximum() *Node {
+    if t.maxNode == nil && !isNil(t.root) {
+        return t.getMaximum(t.root)
+    }
+    return t.maxNode
+}
+
+// refreshExtremes recomputes the cached nodes of the least & greatest
+// keys after a write that may have removed or replaced them.
+func (t *Tree) refreshExtremes() {
+    if isNil(t.root) {
+        t.minNode, t.maxNode = nil, nil
+        return
+    }
+    t.minNode, t.maxNode = t.getMinimum(t.root), t.getMaximum(t.root)
+}
+
+// PollFirst removes the entry with the smallest key & returns it, e.g. to
+// use the tree as a priority queue. The boolean is false when the tree
+// is empty.
+func (t *Tree) PollFirst() (*Entry, bool) {
+    entry, ok := t.Min()
+    if ok {
+        t.Delete(entry.Key)
+    }
+    return entry, ok
+}
+
+// PollLast removes the entry with the largest key & returns it.
+// The boolean is false when the tree is empty.
+func (t *Tree) PollLast() (*Entry, bool) {
+    entry, ok := t.Max()
+    if ok {
+        t.Delete(entry.Key)
+    }
+    return entry, ok
+}
+
+// Oldest returns the entry with the lowest insertion sequence number (see
+// WithInsertionSeq), found by a full scan. The boolean is false when the
+// tree is empty.
+func (t *Tree) Oldest() (*Entry, bool) {
+    return t.firstBy(seqOf, func(a, b uint64) bool { return a < b })
+}
+
+// Newest returns the entry with the highest insertion sequence number (see
+// WithInsertionSeq), found by a full scan. The boolean is false when the
+// tree is empty.
+func (t *Tree) Newest() (*Entry, bool) {
+    return t.firstBy(seqOf, func(a, b uint64) bool { return a > b })
+}
+
+// WalkInsertionOrder calls `fn` on every entry by ascending insertion
+// sequence number (see WithInsertionSeq) rather than by key, stopping
+// early once `fn` returns false. The entries are collected & sorted on
+// each call, in O(n log n) time & O(n) space. Without WithInsertionSeq
+// all sequence numbers are 0 & the entries come in key order.
+func (t *Tree) WalkInsertionOrder(fn func(key, value interface{}) bool) {
+    var nodes []*Node
+    inorder(t.root, func(n *Node) bool {
+        nodes = append(nodes, n)
+        return true
+    })
+    sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Seq() < nodes[j].Seq() })
+    for _, n := range nodes {
+        if !fn(n.key, n.payload) {
+            return
+        }
+    }
+}
+
+// LeastRecentlyUsed returns the entry accessed the longest ago by Get or
+// Put (see WithAccessTracking), found by a full scan in O(n). The boolean
+// is false when the tree is empty.
+func (t *Tree) LeastRecentlyUsed() (*Entry, bool) {
+    return t.firstBy(accessOf, func(a, b uint64) bool { return a < b })
+}
+
+func seqOf(n *Node) uint64 {
+    return n.Seq()
+}
+
+func accessOf(n *Node) uint64 {
+    if n.meta == nil {
+        return 0
+    }
+    return n.meta.access
+}
+
+// firstBy returns the entry whose `rank` comes first according to `before`.
+func (t *Tree) firstBy(rank func(*Node) uint64, before func(a, b uint64) bool) (*Entry, bool) {
+    var best *Node
+    inorder(t.root, func(n *Node) bool {
+        if best == nil || before(rank(n), rank(best)) {
+            best = n
+        }
+        return true
+    })
+    if best == nil {
+        return nil, false
+    }
+    return &Entry{Key: best.key, Value: best.payload}, true
+}
+
+// MinMax returns the smallest & the largest keys with their payloads in
+// one call, e.g. to size an axis. ok is false when the tree is empty.
+// Like Min & Max, it takes O(1) time on the cached extremes.
+func (t *Tree) MinMax() (minKey, minVal, maxKey, maxVal interface{}, ok bool) {
+    if isNil(t.root) {
+        return nil, nil, nil, nil, false
+    }
+    min, max := t.minimum(), t.maximum()
+    return min.key, min.payload, max.key, max.payload, true
+}
+
+// LeftmostLeaf returns the entry of the leftmost node without children,
+// found by going left whenever possible & right otherwise. When the node
+// holding the minimum key (see Min) has no right child, it is that leaf;
+// otherwise the leaf is its right child. The boolean is false when the
+// tree is empty.
+func (t *Tree) LeftmostLeaf() (*Entry, bool) {
+    n := t.root
+    if isNil(n) {
+        return nil, false
+    }
+    for !isNil(n.left) || !isNil(n.right) {
+        if !isNil(n.left) {
+            n = n.left
+        } else {
+            n = n.right
+        }
+    }
+    return &Entry{Key: n.key, Value: n.payload}, true
+}
+
+// RightmostLeaf is the mirror image of LeftmostLeaf. It is the node
+// holding the maximum key (see Max) unless that node has a left child.
+func (t *Tree) RightmostLeaf() (*Entry, bool) {
+    n := t.root
+    if isNil(n) {
+        return nil, false
+    }
+    for !isNil(n.left) || !isNil(n.right) {
+        if !isNil(n.right) {
+            n = n.right
+        } else {
+            n = n.left
+        }
+    }
+    return &Entry{Key: n.key, Value: n.payload}, true
+}
+
 // countingVisitor counts the number
 // of nodes in the tree.
 type countingVisitor struct {
@@ -646,7 +1842,7 @@ type countingVisitor struct {
 }
 
 func (v *countingVisitor) Visit(node *Node) {
-    if node == nil {
+    if isNil(node) {
         return
     }
 
@@ -655,9 +1851,50 @@ func (v *countingVisitor) Visit(node *Node) {
     v.Visit(node.right)
 }
 
+// sizingVisitor accumulates the approximate
+// memory footprint of the nodes it visits.
+type sizingVisitor struct {
+    valueSizer func(interface{}) int
+    Bytes      uint64
+}
+
+func (v *sizingVisitor) Visit(node *Node) {
+    if isNil(node) {
+        return
+    }
+
+    v.Visit(node.left)
+    v.Bytes = v.Bytes + nodeOverheadBytes
+    if node.meta != nil {
+        v.Bytes = v.Bytes + nodeMetaBytes
+    }
+    if v.valueSizer != nil {
+        if size := v.valueSizer(node.payload); size > 0 {
+            v.Bytes = v.Bytes + uint64(size)
+        }
+    }
+    v.Visit(node.right)
+}
+
+// replacingVisitor overwrites the payload of each
+// node it visits, in inorder fashion.
+type replacingVisitor struct {
+    transform func(key, value interface{}) interface{}
+}
+
+func (v *replacingVisitor) Visit(node *Node) {
+    if isNil(node) {
+        return
+    }
+
+    v.Visit(node.left)
+    node.payload = v.transform(node.key, node.payload)
+    v.Visit(node.right)
+}
+
 // InorderVisitor walks the tree in inorder fashion.
-// This visitor maintains internal state; thus do not
-// reuse after the completion of a walk.
+// This visitor maintains internal state; thus call
+// Reset between walks when reusing it.
 type InorderVisitor struct {
     buffer bytes.Buffer
 }
@@ -673,12 +1910,18 @@ func (v *InorderVisitor) trim(s string) string {
     return strings.TrimRight(strings.TrimRight(s, "ed"), "lack")
 }
 
+// Reset clears the output of the previous walk, keeping
+// the underlying buffer for the next one.
+func (v *InorderVisitor) Reset() {
+    v.buffer.Reset()
+}
+
 func (v *InorderVisitor) String() string {
     return v.buffer.String()
 }
 
 func (v *InorderVisitor) Visit(node *Node) {
-    if node == nil {
+    if isNil(node) {
         v.buffer.Write([]byte("."))
         return
     }
@@ -690,11 +1933,90 @@ func (v *InorderVisitor) Visit(node *Node) {
     v.buffer.Write([]byte(")"))
 }
 
+// PredicateVisitor walks the tree in inorder fashion, collecting
+// the entries which satisfy its predicate.
+type PredicateVisitor struct {
+    match   func(key, value interface{}) bool
+    results []Entry
+}
+
+// NewPredicateVisitor returns a visitor collecting the entries
+// for which `match` returns true.
+func NewPredicateVisitor(match func(key, value interface{}) bool) *PredicateVisitor {
+    return &PredicateVisitor{match: match}
+}
+
+func (v *PredicateVisitor) Visit(node *Node) {
+    if isNil(node) {
+        return
+    }
+    v.Visit(node.left)
+    if v.match(node.key, node.payload) {
+        v.results = append(v.results, Entry{Key: node.key, Value: node.payload})
+    }
+    v.Visit(node.right)
+}
+
+// Results returns the matching entries of the walks so far,
+// in ascending key order.
+func (v *PredicateVisitor) Results() []Entry {
+    results := make([]Entry, len(v.results))
+    copy(results, v.results)
+    return results
+}
+
+// Every error of the package belongs to one of two categories, which
+// callers can test with `errors.Is`: ErrorInvalidKey for a key that cannot
+// be stored or compared, ErrorInvalidOperation for anything else that
+// makes an operation fail.
+var (
+    ErrorInvalidKey = errors.New("Invalid key")
+    ErrorInvalidOperation = errors.New("Invalid operation")
+)
+
 var (
-    ErrorKeyIsNil = errors.New("The literal nil not allowed as keys")
-    ErrorKeyDisallowed = errors.New("Disallowed key type")
+    ErrorKeyIsNil = keyError("The literal nil not allowed as keys")
+    ErrorKeyDisallowed = keyError("Disallowed key type")
+    ErrorLengthMismatch = operationError("Keys and values differ in length")
+    ErrorUnknownOperation = operationError("Unknown operation")
+    ErrorKeyIncomparable = keyError("Key cannot be compared by the comparator")
+    ErrorNilValue = operationError("The literal nil not allowed as values")
+    ErrorKeyNotInt = keyError("Only int keys have a binary encoding")
+    ErrorKeyTypeUnsupported = keyError("No built-in comparator for the key type")
+    ErrorKeysOutOfOrder = operationError("Keys are not in ascending order")
+    ErrorNodeNotInTree = operationError("Node does not belong to the tree")
+    ErrorDuplicateKey = operationError("Duplicate key")
+    ErrorBrokenLink = operationError("Inconsistent link between nodes")
+    ErrorComparatorMismatch = operationError("Comparators disagree on the order of keys")
+    ErrorKeyKindMismatch = keyError("Key kind differs from the kind of the stored keys")
+    ErrorCorruptInput = operationError("Corrupt input")
+    ErrorUnknownComparator = operationError("No comparator registered under that name")
+    ErrorTreeFull = operationError("No room left for another node")
+    ErrorKeyUnhashable = keyError("Key cannot be used as a map key")
 )
 
+// categorizedError is an error that unwraps to its category.
+type categorizedError struct {
+    msg string
+    category error
+}
+
+func (e *categorizedError) Error() string {
+    return e.msg
+}
+
+func (e *categorizedError) Unwrap() error {
+    return e.category
+}
+
+func keyError(msg string) error {
+    return &categorizedError{msg: msg, category: ErrorInvalidKey}
+}
+
+func operationError(msg string) error {
+    return &categorizedError{msg: msg, category: ErrorInvalidOperation}
+}
+
 // Allowed key types are: Boolean, Integer, Floating point, Complex, String values
 // And structs containing these. 
 // @TODO Should pointer type be allowed ?
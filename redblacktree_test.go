@@ -18,9 +18,18 @@ License.
 package redblacktree
 
 import (
-    _ "fmt"
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "math/rand"
     "reflect"
     "sort"
+    "sync"
+    "strconv"
+    "strings"
     "testing"
 )
 
@@ -106,6 +115,52 @@ func assertEqual(expected uint64, actual uint64, t *testing.T) {
     }
 }
 
+// assertRedBlack checks the red-black properties, the ordering of keys
+// & the parent links of every node in the tree.
+func assertRedBlack(tr *Tree, t *testing.T) {
+    if isRed(tr.root) {
+        t.Errorf("Expected root to be Black")
+    }
+    if !isNil(tr.root) && !isNil(tr.root.parent) {
+        t.Errorf("Expected root %s to have no parent", tr.root)
+    }
+    var blackHeight func(n *Node) int
+    blackHeight = func(n *Node) int {
+        if isNil(n) {
+            if n != nil && n.color != BLACK {
+                t.Errorf("Expected sentinel to be Black")
+            }
+            return 1
+        }
+        for _, child := range []*Node{n.left, n.right} {
+            if isNil(child) {
+                continue
+            }
+            if child.parent != n {
+                t.Errorf("Expected parent of %s to be %s", child, n)
+            }
+            if isRed(n) && isRed(child) {
+                t.Errorf("Red node %s has a Red child %s", n, child)
+            }
+        }
+        if !isNil(n.left) && tr.cmp(n.left.key, n.key) >= 0 {
+            t.Errorf("Left child %s is not less than %s", n.left, n)
+        }
+        if !isNil(n.right) && tr.cmp(n.right.key, n.key) <= 0 {
+            t.Errorf("Right child %s is not greater than %s", n.right, n)
+        }
+        left, right := blackHeight(n.left), blackHeight(n.right)
+        if left != right {
+            t.Errorf("Black height of %s differs: left=%d right=%d", n, left, right)
+        }
+        if n.color == BLACK {
+            return left + 1
+        }
+        return left
+    }
+    blackHeight(tr.root)
+}
+
 // using the inorder walk of the tree for equality
 func assertEqualTree(tr *Tree, t *testing.T, expected string) {
     visitor := &InorderVisitor{}
@@ -170,7 +225,7 @@ type KV struct {
     arg string
 }
 
-type Operation struct {
+type operation struct {
     ops string
     kv  KV
 }
@@ -196,8 +251,8 @@ var fixtureSmall = []struct {
 
 func TestRedBlackSmall(t *testing.T) {
     t1 := NewTree()
-    if t1.root != nil {
-        t.Errorf("root starts out as nil but got (%t)", t1.root != nil)
+    if !isNil(t1.root) {
+        t.Errorf("root starts out as nil but got (%t)", !isNil(t1.root))
     }
     assertEqualTree(t1, t, ".")
 
@@ -356,7 +411,7 @@ func TestRedBlackParentLookup(t *testing.T) {
     assertDirection(RIGHT, dir, t)
 }
 
-var treeData = []Operation{
+var treeData = []operation{
     {"put", KV{7, "payload7"}},
     {"put", KV{3, "payload3"}},
     {"put", KV{18, "payload18"}},
@@ -457,9 +512,9 @@ func TestLeftRotateProperly(t *testing.T) {
     assertEqualTree(t1, t, "(((.3.)7(.8.))10(((.11.)18(.22.))26(.30.)))")
 }
 
-type By func(o1, o2 *Operation) bool
+type By func(o1, o2 *operation) bool
 
-func (b By) Sort(ops []Operation) {
+func (b By) Sort(ops []operation) {
     os := &operationSorter{
         operations: ops,
         by:         b,
@@ -468,8 +523,8 @@ func (b By) Sort(ops []Operation) {
 }
 
 type operationSorter struct {
-    operations []Operation
-    by         func(o1, o2 *Operation) bool
+    operations []operation
+    by         func(o1, o2 *operation) bool
 }
 
 func (k operationSorter) Len() int {
@@ -484,7 +539,7 @@ func (k operationSorter) Less(i, j int) bool {
     return k.by(&k.operations[i], &k.operations[j])
 }
 
-var treeData2 = []Operation{
+var treeData2 = []operation{
     {"put", KV{1, "payload1"}},
     {"put", KV{2, "payload2"}},
     {"put", KV{3, "payload3"}},
@@ -500,10 +555,10 @@ var treeData2 = []Operation{
 // 1. keys are in ascending order (sorted)
 // 2. keys are in descending order (!sorted)
 func TestWorstCases(t *testing.T) {
-    increasingKey := func(o1, o2 *Operation) bool {
+    increasingKey := func(o1, o2 *operation) bool {
         return o1.kv.key < o2.kv.key
     }
-    decreasingKey := func(o1, o2 *Operation) bool {
+    decreasingKey := func(o1, o2 *operation) bool {
         return !increasingKey(o1, o2)
     }
 
@@ -761,6 +816,72 @@ func TestIntComparator(t *testing.T) {
     }
 }
 
+var fixtureComparatorBoundaries = []struct {
+    op1, op2 int
+    expected int
+}{
+    {math.MinInt, math.MinInt, 0},
+    {math.MaxInt, math.MaxInt, 0},
+    {math.MinInt, math.MaxInt, -1},
+    {math.MaxInt, math.MinInt, 1},
+    {math.MinInt, 0, -1},
+    {0, math.MinInt, 1},
+    {math.MaxInt, -1, 1},
+    {-1, math.MaxInt, -1},
+    {math.MinInt, 1, -1},
+    {1, math.MinInt, 1},
+}
+
+// Subtracting keys would overflow for these pairs.
+func TestIntComparatorBoundaries(t *testing.T) {
+    for _, tt := range fixtureComparatorBoundaries {
+        actual := IntComparator(tt.op1, tt.op2)
+        if actual != tt.expected {
+            t.Errorf("IntComparator(%d, %d): expected (%d) got (%d)", tt.op1, tt.op2, tt.expected, actual)
+        }
+    }
+}
+
+var fixtureSignedKeys = []struct {
+    ops      string
+    key      int
+    arg      string
+    expected string
+    size     int
+}{
+    {"put",    0,           "zero",  "(.0.)", 1},
+    {"put",    -1,          "neg1",  "((.-1.)0.)", 2},
+    {"put",    math.MinInt, "min",   "((.-9223372036854775808.)-1(.0.))", 3},
+    {"put",    math.MaxInt, "max",   "((.-9223372036854775808.)-1(.0(.9223372036854775807.)))", 4},
+    {"put",    1,           "one",   "((.-9223372036854775808.)-1((.0.)1(.9223372036854775807.)))", 5},
+    {"put",    0,           "zero+", "((.-9223372036854775808.)-1((.0.)1(.9223372036854775807.)))", 5},
+    {"delete", -1,          "",      "((.-9223372036854775808.)0(.1(.9223372036854775807.)))", 4},
+    {"delete", math.MaxInt, "",      "((.-9223372036854775808.)0(.1.))", 3},
+    {"delete", math.MinInt, "",      "(.0(.1.))", 2},
+}
+
+func TestSignedKeys(t *testing.T) {
+    if math.MaxInt != math.MaxInt64 {
+        t.Skip("fixture assumes 64-bit int")
+    }
+    tr := NewTree()
+    for _, tt := range fixtureSignedKeys {
+        method := funcs[tt.ops]
+        switch {
+        case tt.ops == "put":
+            method.Func.Call(ToArgs(tr, tt.key, tt.arg))
+            ok, payload := tr.Get(tt.key)
+            True(ok, t)
+            assertPayloadString(tt.arg, payload.(string), t)
+        case tt.ops == "delete":
+            method.Func.Call(ToArgs(tr, tt.key))
+            False(tr.Has(tt.key), t)
+        }
+        assertEqualTree(tr, t, tt.expected)
+        assertEqual(uint64(tt.size), tr.Size(), t)
+    }
+}
+
 var fixtureComparatorString = []struct {
     op1, op2 string
     expected int
@@ -871,3 +992,3827 @@ func TestKeyComparator(t *testing.T) {
         assertEqual(uint64(tt.size), tr.Size(), t)
     }
 }
+
+func TestReplaceAll(t *testing.T) {
+    t1 := NewTree()
+    t1.ReplaceAll(func(key, value interface{}) interface{} {
+        t.Errorf("transform called on an empty tree")
+        return value
+    })
+
+    for _, tt := range treeData2 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    before := &InorderVisitor{}
+    t1.Walk(before)
+
+    var order []int
+    t1.ReplaceAll(func(key, value interface{}) interface{} {
+        order = append(order, key.(int))
+        return value.(string) + "+"
+    })
+    True(sort.IntsAreSorted(order), t)
+    assertEqual(uint64(len(treeData2)), uint64(len(order)), t)
+    assertEqualTree(t1, t, before.String())
+
+    for _, tt := range treeData2 {
+        ok, payload := t1.Get(tt.kv.key)
+        True(ok, t)
+        assertPayloadString(tt.kv.arg+"+", payload.(string), t)
+    }
+
+    // a nil transform is a noop
+    t1.ReplaceAll(nil)
+    ok, payload := t1.Get(1)
+    True(ok, t)
+    assertPayloadString("payload1+", payload.(string), t)
+}
+
+func TestApproxMemoryBytes(t *testing.T) {
+    t1 := NewTree()
+    assertEqual(0, t1.ApproxMemoryBytes(nil), t)
+
+    for _, tt := range treeData2 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    count := uint64(len(treeData2))
+    assertEqual(count*nodeOverheadBytes, t1.ApproxMemoryBytes(nil), t)
+
+    var payloadBytes uint64
+    for _, tt := range treeData2 {
+        payloadBytes = payloadBytes + uint64(len(tt.kv.arg))
+    }
+    stringSizer := func(v interface{}) int {
+        return len(v.(string))
+    }
+    assertEqual(count*nodeOverheadBytes+payloadBytes, t1.ApproxMemoryBytes(stringSizer), t)
+
+    // negative sizes are ignored
+    negativeSizer := func(v interface{}) int {
+        return -1
+    }
+    assertEqual(count*nodeOverheadBytes, t1.ApproxMemoryBytes(negativeSizer), t)
+}
+
+func TestSentinel(t *testing.T) {
+    t1 := NewTree()
+    NotNil(t1.root, t)
+    True(t1.root == t1.sentinel, t)
+    assertNodeColor(BLACK, t1.sentinel.color, t)
+
+    t1.Put(7, "payload7")
+    t1.Put(3, "payload3")
+    True(t1.root.left.left == t1.sentinel, t)
+    True(t1.root.right == t1.sentinel, t)
+    Nil(t1.root.Parent(), t)
+    True(t1.root.left.Parent() == t1.root, t)
+
+    t1.Delete(3)
+    t1.Delete(7)
+    True(t1.root == t1.sentinel, t)
+    assertEqualTree(t1, t, ".")
+    assertEqual(0, t1.Size(), t)
+
+    // trees from a literal have nil leaves until the sentinel is needed
+    t2 := &Tree{root: &Node{key: 7, color: BLACK, left: &Node{key: 3, color: RED}}, cmp: IntComparator}
+    t2.root.left.parent = t2.root
+    assertEqualTree(t2, t, "((.3.)7.)")
+    t2.Put(9, "payload9")
+    assertEqualTree(t2, t, "((.3.)7(.9.))")
+    t2.Delete(3)
+    t2.Delete(7)
+    assertEqualTree(t2, t, "(.9.)")
+    assertRedBlack(t2, t)
+}
+
+// Deletes in random order keep the red-black properties intact.
+func TestDeleteRandom(t *testing.T) {
+    r := rand.New(rand.NewSource(616))
+    for round := 0; round < 20; round++ {
+        tr := NewTree()
+        keys := r.Perm(200)
+        for _, k := range keys {
+            tr.Put(k, k)
+            assertRedBlack(tr, t)
+        }
+        for i, k := range r.Perm(200) {
+            tr.Delete(k)
+            False(tr.Has(k), t)
+            assertEqual(uint64(200-i-1), tr.Size(), t)
+            assertRedBlack(tr, t)
+        }
+        True(isNil(tr.root), t)
+    }
+}
+
+var fixtureKeysBetween = []struct {
+    lo, hi       int
+    loInc, hiInc bool
+    limit        int
+    expected     []int
+}{
+    {3, 7, true, true, 0, []int{3, 4, 5, 6, 7}},
+    {3, 7, true, false, 0, []int{3, 4, 5, 6}},
+    {3, 7, false, true, 0, []int{4, 5, 6, 7}},
+    {3, 7, false, false, 0, []int{4, 5, 6}},
+    {5, 5, true, true, 0, []int{5}},
+    {5, 5, true, false, 0, []int{}},
+    {7, 3, true, true, 0, []int{}},
+    {-10, 100, true, true, 0, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+    {0, 1, false, false, 0, []int{}},
+    {2, 8, true, true, 3, []int{2, 3, 4}},
+}
+
+func TestKeysBetween(t *testing.T) {
+    t1 := NewTree()
+    t1.KeysBetween(0, true, 10, true, func(key interface{}) bool {
+        t.Errorf("Expected no keys in an empty tree")
+        return true
+    })
+
+    for _, tt := range treeData2 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    for _, tt := range fixtureKeysBetween {
+        actual := []int{}
+        t1.KeysBetween(tt.lo, tt.loInc, tt.hi, tt.hiInc, func(key interface{}) bool {
+            actual = append(actual, key.(int))
+            return tt.limit == 0 || len(actual) < tt.limit
+        })
+        if !reflect.DeepEqual(tt.expected, actual) {
+            t.Errorf("KeysBetween(%d, %t, %d, %t): expected %v got %v", tt.lo, tt.loInc, tt.hi, tt.hiInc, tt.expected, actual)
+        }
+    }
+
+    // invalid endpoints & nil callback are noops
+    t1.KeysBetween(nil, true, 10, true, func(key interface{}) bool {
+        t.Errorf("Expected no keys for a nil endpoint")
+        return true
+    })
+    t1.KeysBetween(1, true, 10, true, nil)
+}
+
+// mustPanic asserts that `f` panics
+func mustPanic(f func(), t *testing.T) {
+    defer func() {
+        if r := recover(); r == nil {
+            t.Errorf("Expected a panic")
+        }
+    }()
+    f()
+}
+
+func TestComparatorCheck(t *testing.T) {
+    t1 := NewTree(WithComparatorCheck())
+    True(t1.checkCmp, t)
+    False(NewTree().checkCmp, t)
+    for _, tt := range treeData {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    assertEqual(uint64(len(treeData)), t1.Size(), t)
+
+    // never returns zero, even for equal keys
+    irreflexive := func(o1, o2 interface{}) int {
+        if IntComparator(o1, o2) <= 0 {
+            return -1
+        }
+        return 1
+    }
+    t2 := NewTreeWith(irreflexive, WithComparatorCheck())
+    mustPanic(func() { t2.Put(1, "payload1") }, t)
+
+    // claims every key is smaller than the other one
+    asymmetric := func(o1, o2 interface{}) int {
+        if IntComparator(o1, o2) == 0 {
+            return 0
+        }
+        return -1
+    }
+    t3 := NewTreeWith(asymmetric, WithComparatorCheck())
+    t3.Put(1, "payload1")
+    mustPanic(func() { t3.Put(2, "payload2") }, t)
+
+    // without the check, the broken comparator goes unnoticed
+    t4 := NewTreeWith(asymmetric)
+    t4.Put(1, "payload1")
+    t4.Put(2, "payload2")
+}
+
+func TestGetDetailed(t *testing.T) {
+    t1 := NewTree()
+    _, _, _, ok := t1.GetDetailed(1)
+    False(ok, t)
+
+    for _, tt := range treeData2 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    // ((((.1.)2(.3.))4(.5.))6((.7.)8(.9.)))
+    var fixture = []struct {
+        key   int
+        depth int
+    }{
+        {6, 0}, {4, 1}, {8, 1}, {2, 2}, {5, 2}, {7, 2}, {9, 2}, {1, 3}, {3, 3},
+    }
+    for _, tt := range fixture {
+        value, color, depth, ok := t1.GetDetailed(tt.key)
+        True(ok, t)
+        assertPayloadString("payload"+string(rune('0'+tt.key)), value.(string), t)
+        _, node := t1.getNode(tt.key)
+        assertNodeColor(node.color, color, t)
+        assertEqual(uint64(tt.depth), uint64(depth), t)
+    }
+    _, color, _, _ := t1.GetDetailed(6)
+    assertNodeColor(BLACK, color, t)
+
+    _, _, _, ok = t1.GetDetailed(10)
+    False(ok, t)
+    _, _, _, ok = t1.GetDetailed(nil)
+    False(ok, t)
+}
+
+func TestBuildSorted(t *testing.T) {
+    for n := 0; n < 70; n++ {
+        keys, payloads := make([]interface{}, n), make([]interface{}, n)
+        for i := 0; i < n; i++ {
+            keys[i], payloads[i] = i, i*10
+        }
+        tr := NewTree()
+        tr.buildSorted(keys, payloads)
+        assertRedBlack(tr, t)
+        assertEqual(uint64(n), tr.Size(), t)
+        for i := 0; i < n; i++ {
+            ok, payload := tr.Get(i)
+            True(ok, t)
+            True(payload.(int) == i*10, t)
+        }
+        // the bulk-loaded tree keeps working with Put & Delete
+        tr.Put(n, n*10)
+        tr.Delete(0)
+        assertRedBlack(tr, t)
+    }
+}
+
+func TestFilter(t *testing.T) {
+    t1 := NewTree()
+    for _, tt := range treeData2 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    before := &InorderVisitor{}
+    t1.Walk(before)
+
+    even := t1.Filter(func(key, value interface{}) bool {
+        return key.(int)%2 == 0
+    })
+    assertEqualTree(even, t, "((.2.)4(.6(.8.)))")
+    assertRedBlack(even, t)
+    ok, payload := even.Get(8)
+    True(ok, t)
+    assertPayloadString("payload8", payload.(string), t)
+    False(even.Has(1), t)
+
+    // the original is untouched
+    assertEqualTree(t1, t, before.String())
+    assertEqual(uint64(len(treeData2)), t1.Size(), t)
+
+    none := t1.Filter(func(key, value interface{}) bool {
+        return false
+    })
+    assertEqualTree(none, t, ".")
+    none.Put(1, "payload1")
+    assertEqualTree(none, t, "(.1.)")
+
+    // the comparator is carried over
+    s1 := NewTreeWith(StringComparator)
+    s1.Put("b", 2)
+    s1.Put("a", 1)
+    s2 := s1.Filter(func(key, value interface{}) bool {
+        return true
+    })
+    s2.Put("c", 3)
+    assertEqual(3, s2.Size(), t)
+}
+
+func TestMap(t *testing.T) {
+    t1 := NewTree()
+    for _, tt := range treeData2 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    before := &InorderVisitor{}
+    t1.Walk(before)
+
+    t2 := t1.Map(func(key, value interface{}) interface{} {
+        return len(value.(string)) + key.(int)
+    })
+    assertRedBlack(t2, t)
+    assertEqual(t1.Size(), t2.Size(), t)
+    for _, tt := range treeData2 {
+        ok, payload := t2.Get(tt.kv.key)
+        True(ok, t)
+        True(payload.(int) == len(tt.kv.arg)+tt.kv.key, t)
+
+        // the original is untouched
+        ok, payload = t1.Get(tt.kv.key)
+        True(ok, t)
+        assertPayloadString(tt.kv.arg, payload.(string), t)
+    }
+    assertEqualTree(t1, t, before.String())
+
+    assertEqualTree(NewTree().Map(func(key, value interface{}) interface{} {
+        return value
+    }), t, ".")
+}
+
+func TestFold(t *testing.T) {
+    t1 := NewTree()
+    sum := func(acc, key, value interface{}) interface{} {
+        return acc.(int) + key.(int)
+    }
+    True(t1.Fold(42, sum).(int) == 42, t)
+
+    for _, tt := range treeData2 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    True(t1.Fold(0, sum).(int) == 45, t)
+
+    concat := t1.Fold("", func(acc, key, value interface{}) interface{} {
+        return acc.(string) + value.(string)[len("payload"):]
+    })
+    assertPayloadString("123456789", concat.(string), t)
+}
+
+func TestFloorCeiling(t *testing.T) {
+    t1 := NewTree()
+    Nil(t1.floorNode(1), t)
+    Nil(t1.ceilingNode(1), t)
+
+    for _, k := range []int{10, 20, 30, 40} {
+        t1.Put(k, k)
+    }
+    var fixture = []struct {
+        key, floor, ceiling int // 0 means none
+    }{
+        {5, 0, 10}, {10, 10, 10}, {15, 10, 20}, {30, 30, 30}, {39, 30, 40}, {45, 40, 0},
+    }
+    for _, tt := range fixture {
+        floor, ceiling := t1.floorNode(tt.key), t1.ceilingNode(tt.key)
+        if tt.floor == 0 {
+            Nil(floor, t)
+        } else {
+            assertNodeKey(floor, tt.floor, t)
+        }
+        if tt.ceiling == 0 {
+            Nil(ceiling, t)
+        } else {
+            assertNodeKey(ceiling, tt.ceiling, t)
+        }
+    }
+}
+
+func TestGetClosest(t *testing.T) {
+    intDistance := func(o1, o2 interface{}) float64 {
+        return math.Abs(float64(o1.(int) - o2.(int)))
+    }
+    t1 := NewTree()
+    t2 := NewTree(WithDistance(intDistance))
+    _, ok := t1.GetClosest(1)
+    False(ok, t)
+    _, ok = t2.GetClosest(1)
+    False(ok, t)
+
+    for _, k := range []int{10, 20, 30} {
+        t1.Put(k, "payload"+string(rune('0'+k/10)))
+        t2.Put(k, "payload"+string(rune('0'+k/10)))
+    }
+    var fixture = []struct {
+        key        int
+        floorFirst int // without a Distance
+        closest    int // with a Distance
+    }{
+        {10, 10, 10}, {1, 10, 10}, {99, 30, 30}, {12, 10, 10}, {18, 10, 20}, {15, 10, 10}, {26, 20, 30},
+    }
+    for _, tt := range fixture {
+        entry, ok := t1.GetClosest(tt.key)
+        True(ok, t)
+        True(entry.Key.(int) == tt.floorFirst, t)
+        assertPayloadString("payload"+string(rune('0'+tt.floorFirst/10)), entry.Value.(string), t)
+
+        entry, ok = t2.GetClosest(tt.key)
+        True(ok, t)
+        True(entry.Key.(int) == tt.closest, t)
+    }
+    _, ok = t1.GetClosest(nil)
+    False(ok, t)
+}
+
+func TestSetLogger(t *testing.T) {
+    defer TraceOff()
+
+    var buf bytes.Buffer
+    SetLogger(log.New(&buf, "rbt: ", 0))
+    t1 := NewTree()
+    t1.Put(1, "payload1")
+    True(strings.HasPrefix(buf.String(), "rbt: Added"), t)
+
+    buf.Reset()
+    SetLogger(nil)
+    t1.Put(2, "payload2")
+    assertEqual(0, uint64(buf.Len()), t)
+
+    SetOutput(&buf)
+    t1.Put(3, "payload3")
+    True(buf.Len() > 0, t)
+}
+
+func TestLastFixupTrace(t *testing.T) {
+    defer TraceOff()
+
+    t1 := NewTree()
+    t1.Put(7, 7)
+    t1.Put(8, 8)
+    t1.Put(9, 9)
+    // tracing is off
+    assertEqual(0, uint64(len(t1.LastFixupTrace())), t)
+
+    var buf bytes.Buffer
+    SetOutput(&buf)
+    var fixture = []struct {
+        ops      string
+        key      int
+        expected []string
+    }{
+        {"put", 11, []string{"put case 1 at 11"}},
+        {"put", 10, []string{"put case 2 at 10", "put case 3 at 11"}},
+        {"put", 12, []string{"put case 1 at 12"}},
+        {"put", 13, []string{"put case 3 at 13"}},
+        {"put", 12, []string{}},
+        {"delete", 7, []string{"delete case 1 at <nil>", "delete case 2 at <nil>"}},
+        {"delete", 13, []string{}},
+        {"delete", 12, []string{}},
+    }
+    for _, tt := range fixture {
+        switch tt.ops {
+        case "put":
+            t1.Put(tt.key, tt.key)
+        case "delete":
+            t1.Delete(tt.key)
+        }
+        assertRedBlack(t1, t)
+        if actual := t1.LastFixupTrace(); !reflect.DeepEqual(tt.expected, actual) {
+            t.Errorf("%s(%d): expected %q got %q", tt.ops, tt.key, tt.expected, actual)
+        }
+    }
+
+    // ((.5.)10((.15.)20.))
+    t2 := NewTree()
+    for _, k := range []int{10, 5, 20, 15} {
+        t2.Put(k, k)
+    }
+    t2.Delete(5)
+    expected := []string{"delete case 3 at <nil>", "delete case 4 at <nil>"}
+    if actual := t2.LastFixupTrace(); !reflect.DeepEqual(expected, actual) {
+        t.Errorf("delete(5): expected %q got %q", expected, actual)
+    }
+    assertEqualTree(t2, t, "((.10.)15(.20.))")
+}
+
+func TestDiagnose(t *testing.T) {
+    t1 := NewTree()
+    assertEqual(0, uint64(len(t1.Diagnose())), t)
+    for _, tt := range treeData {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    assertEqual(0, uint64(len(t1.Diagnose())), t)
+
+    // ((.1.)2(.3.)) with every node Red & a broken parent pointer
+    t2 := NewTree()
+    for _, k := range []int{2, 1, 3} {
+        t2.Put(k, k)
+    }
+    t2.root.color = RED
+    t2.root.left.parent = t2.root.right
+    expected := []string{
+        "root 2 is Red",
+        "node 1 has parent 3 instead of 2",
+        "Red node 2 has Red child 1",
+        "Red node 2 has Red child 3",
+    }
+    if actual := t2.Diagnose(); !reflect.DeepEqual(expected, actual) {
+        t.Errorf("Expected %q got %q", expected, actual)
+    }
+
+    // (.2(.3.)) with a Black leaf on the right only
+    t3 := NewTree()
+    t3.Put(2, 2)
+    t3.Put(3, 3)
+    t3.root.right.color = BLACK
+    expected = []string{"node 2 has black height 1 on the left but 2 on the right"}
+    if actual := t3.Diagnose(); !reflect.DeepEqual(expected, actual) {
+        t.Errorf("Expected %q got %q", expected, actual)
+    }
+}
+
+var fixtureHasInRange = []struct {
+    lo, hi   int
+    expected bool
+}{
+    {10, 10, true},
+    {11, 19, false},
+    {11, 20, true},
+    {-5, 9, false},
+    {-5, 10, true},
+    {41, 100, false},
+    {40, 100, true},
+    {1, 100, true},
+    {30, 20, false},
+}
+
+func TestHasInRange(t *testing.T) {
+    t1 := NewTree()
+    False(t1.HasInRange(0, 100), t)
+    for _, k := range []int{10, 20, 30, 40} {
+        t1.Put(k, k)
+    }
+    for _, tt := range fixtureHasInRange {
+        if actual := t1.HasInRange(tt.lo, tt.hi); actual != tt.expected {
+            t.Errorf("HasInRange(%d, %d): expected %t got %t", tt.lo, tt.hi, tt.expected, actual)
+        }
+    }
+    False(t1.HasInRange(nil, 100), t)
+}
+
+var fixtureSumRange = []struct {
+    lo, hi   int
+    expected float64
+}{
+    {1, 9, 45},
+    {3, 5, 12},
+    {5, 5, 5},
+    {-10, 2, 3},
+    {8, 100, 17},
+    {10, 20, 0},
+    {5, 3, 0},
+}
+
+func TestSumRange(t *testing.T) {
+    value := func(payload interface{}) float64 {
+        return float64(payload.(int))
+    }
+    t1 := NewTree()
+    True(t1.SumRange(1, 9, value) == 0, t)
+    for _, tt := range treeData2 {
+        t1.Put(tt.kv.key, tt.kv.key)
+    }
+    for _, tt := range fixtureSumRange {
+        if actual := t1.SumRange(tt.lo, tt.hi, value); actual != tt.expected {
+            t.Errorf("SumRange(%d, %d): expected %g got %g", tt.lo, tt.hi, tt.expected, actual)
+        }
+    }
+}
+
+func TestNewTreeFromSlices(t *testing.T) {
+    _, err := NewTreeFromSlices(IntComparator, []interface{}{1, 2}, []interface{}{"a"})
+    if err != ErrorLengthMismatch {
+        t.Errorf("Expected %#v got %#v", ErrorLengthMismatch, err)
+    }
+    _, err = NewTreeFromSlices(IntComparator, []interface{}{1, nil}, []interface{}{"a", "b"})
+    if err != ErrorKeyIsNil {
+        t.Errorf("Expected %#v got %#v", ErrorKeyIsNil, err)
+    }
+
+    empty, err := NewTreeFromSlices(IntComparator, nil, nil)
+    Nil(err, t)
+    assertEqualTree(empty, t, ".")
+
+    // sorted input is bulk-loaded
+    sorted, err := NewTreeFromSlices(IntComparator,
+        []interface{}{1, 2, 3, 4, 5}, []interface{}{"a", "b", "c", "d", "e"})
+    Nil(err, t)
+    assertEqualTree(sorted, t, "((.1(.2.))3(.4(.5.)))")
+    assertRedBlack(sorted, t)
+
+    // unsorted input, with a duplicate, falls back to Put
+    unsorted, err := NewTreeFromSlices(IntComparator,
+        []interface{}{5, 1, 4, 2, 3, 1}, []interface{}{"e", "a", "d", "b", "c", "a+"})
+    Nil(err, t)
+    assertRedBlack(unsorted, t)
+    assertEqual(5, unsorted.Size(), t)
+    ok, payload := unsorted.Get(1)
+    True(ok, t)
+    assertPayloadString("a+", payload.(string), t)
+
+    strs, err := NewTreeFromSlices(StringComparator, []interface{}{"au", "fr"}, []interface{}{61, 63})
+    Nil(err, t)
+    True(strs.Has("fr"), t)
+}
+
+func TestSet(t *testing.T) {
+    s := NewSet()
+    assertEqual(0, s.Size(), t)
+    False(s.Contains(1), t)
+
+    for _, k := range []int{5, 3, 8, 1} {
+        True(s.Add(k), t)
+    }
+    False(s.Add(3), t)
+    False(s.Add(nil), t)
+    assertEqual(4, s.Size(), t)
+    True(s.Contains(8), t)
+
+    True(s.Remove(8), t)
+    False(s.Remove(8), t)
+    False(s.Contains(8), t)
+    assertEqual(3, s.Size(), t)
+
+    var keys []int
+    s.Each(func(key interface{}) bool {
+        keys = append(keys, key.(int))
+        return true
+    })
+    if expected := []int{1, 3, 5}; !reflect.DeepEqual(expected, keys) {
+        t.Errorf("Expected %v got %v", expected, keys)
+    }
+
+    // stop early
+    keys = nil
+    s.Each(func(key interface{}) bool {
+        keys = append(keys, key.(int))
+        return false
+    })
+    assertEqual(1, uint64(len(keys)), t)
+
+    strs := NewSetWith(StringComparator)
+    True(strs.Add("au"), t)
+    True(strs.Contains("au"), t)
+
+    // RejectNilValue would refuse every key
+    strict := NewSet(RejectNilValue())
+    True(strict.Add(1), t)
+    True(strict.Contains(1), t)
+    assertEqual(1, strict.Size(), t)
+}
+
+func TestReplayLog(t *testing.T) {
+    r := rand.New(rand.NewSource(635))
+    original := NewTree()
+    var ops []Operation
+    for i := 0; i < 500; i++ {
+        key := r.Intn(100)
+        if r.Intn(3) == 0 {
+            original.Delete(key)
+            ops = append(ops, Operation{Kind: DELETE, Key: key})
+        } else {
+            original.Put(key, i)
+            ops = append(ops, Operation{Kind: PUT, Key: key, Value: i})
+        }
+    }
+
+    replayed, err := ReplayLog(IntComparator, ops)
+    Nil(err, t)
+    assertRedBlack(replayed, t)
+    expected := &InorderVisitor{}
+    original.Walk(expected)
+    assertEqualTree(replayed, t, expected.String())
+    for key := 0; key < 100; key++ {
+        ok1, payload1 := original.Get(key)
+        ok2, payload2 := replayed.Get(key)
+        True(ok1 == ok2 && payload1 == payload2, t)
+    }
+
+    _, err = ReplayLog(IntComparator, []Operation{{Kind: PUT, Key: 1}, {Kind: OpKind(9), Key: 1}})
+    if err != ErrorUnknownOperation {
+        t.Errorf("Expected %#v got %#v", ErrorUnknownOperation, err)
+    }
+    _, err = ReplayLog(IntComparator, []Operation{{Kind: DELETE, Key: nil}})
+    if err != ErrorKeyIsNil {
+        t.Errorf("Expected %#v got %#v", ErrorKeyIsNil, err)
+    }
+    assertPayloadString("delete", DELETE.String(), t)
+}
+
+func TestShrinkToFit(t *testing.T) {
+    defer TraceOff()
+
+    t1 := NewTree()
+    t1.ShrinkToFit()
+    (&Tree{cmp: IntComparator}).ShrinkToFit()
+
+    var buf bytes.Buffer
+    SetOutput(&buf)
+    for _, k := range []int{10, 5, 20, 15} {
+        t1.Put(k, k)
+    }
+    t1.Delete(5)
+    before := &InorderVisitor{}
+    t1.Walk(before)
+    True(len(t1.LastFixupTrace()) > 0, t)
+    NotNil(t1.sentinel.parent, t)
+
+    t1.ShrinkToFit()
+    assertEqual(0, uint64(len(t1.LastFixupTrace())), t)
+    Nil(t1.sentinel.parent, t)
+    assertEqualTree(t1, t, before.String())
+    assertRedBlack(t1, t)
+
+    // the tree keeps working afterwards
+    t1.Delete(10)
+    t1.Put(1, 1)
+    assertRedBlack(t1, t)
+}
+
+func TestKeysValuesMinMax(t *testing.T) {
+    t1 := NewTree()
+    assertEqual(0, uint64(len(t1.Keys())), t)
+    assertEqual(0, uint64(len(t1.Values())), t)
+    _, ok := t1.Min()
+    False(ok, t)
+    _, ok = t1.Max()
+    False(ok, t)
+
+    for _, k := range []int{5, 3, 8, 1} {
+        t1.Put(k, k*10)
+    }
+    if expected := []interface{}{1, 3, 5, 8}; !reflect.DeepEqual(expected, t1.Keys()) {
+        t.Errorf("Expected %v got %v", expected, t1.Keys())
+    }
+    if expected := []interface{}{10, 30, 50, 80}; !reflect.DeepEqual(expected, t1.Values()) {
+        t.Errorf("Expected %v got %v", expected, t1.Values())
+    }
+    min, ok := t1.Min()
+    True(ok, t)
+    True(*min == Entry{1, 10}, t)
+    max, ok := t1.Max()
+    True(ok, t)
+    True(*max == Entry{8, 80}, t)
+
+    var keys []interface{}
+    t1.Each(func(key, value interface{}) bool {
+        keys = append(keys, key)
+        return len(keys) < 2
+    })
+    if expected := []interface{}{1, 3}; !reflect.DeepEqual(expected, keys) {
+        t.Errorf("Expected %v got %v", expected, keys)
+    }
+}
+
+func TestReadOnly(t *testing.T) {
+    t1 := NewTree()
+    t1.Put(7, "payload7")
+    r := t1.ReadOnly()
+
+    if _, ok := r.(*Tree); ok {
+        t.Errorf("Expected the view not to be a *Tree")
+    }
+    if _, ok := r.(interface{ Put(interface{}, interface{}) error }); ok {
+        t.Errorf("Expected the view to have no Put")
+    }
+
+    True(r.Has(7), t)
+    ok, payload := r.Get(7)
+    True(ok, t)
+    assertPayloadString("payload7", payload.(string), t)
+
+    // changes through the original handle show through
+    t1.Put(3, "payload3")
+    assertEqual(2, r.Size(), t)
+    inorder := &InorderVisitor{}
+    r.Walk(inorder)
+    assertPayloadString("((.3.)7.)", inorder.String(), t)
+    min, _ := r.Min()
+    max, _ := r.Max()
+    True(min.Key == 3 && max.Key == 7, t)
+    assertEqual(2, uint64(len(r.Keys())), t)
+    assertEqual(2, uint64(len(r.Values())), t)
+    count := 0
+    r.Each(func(key, value interface{}) bool {
+        count++
+        return true
+    })
+    assertEqual(2, uint64(count), t)
+
+    // visitors only get to change a copy
+    r.Walk(&recoloringVisitor{})
+    assertRedBlack(t1, t)
+    assertEqualTree(t1, t, "((.3.)7.)")
+
+    // a tree satisfies the interface as well
+    var _ ReadOnlyTree = t1
+}
+
+// recoloringVisitor paints Red every node it can reach through the
+// exported methods of Node, as a visitor outside the package would.
+type recoloringVisitor struct{}
+
+func (v *recoloringVisitor) Visit(node *Node) {
+    for _, n := range []*Node{node, node.Parent(), node.Sibling(), node.Uncle()} {
+        if n != nil {
+            n.SetColor(RED)
+        }
+    }
+}
+
+func TestNewTreeWithCapacity(t *testing.T) {
+    t1 := NewTreeWithCapacity(IntComparator, 4)
+    assertEqual(4, uint64(len(t1.spare)), t)
+    for k := 1; k <= 9; k++ {
+        t1.Put(k, k)
+    }
+    assertEqual(0, uint64(len(t1.spare)), t)
+    assertEqualTree(t1, t, "(((.1.)2(.3.))4((.5.)6((.7.)8(.9.))))")
+    assertRedBlack(t1, t)
+    t1.Delete(4)
+    t1.Delete(1)
+    assertRedBlack(t1, t)
+
+    // unused nodes are dropped by ShrinkToFit
+    t2 := NewTreeWithCapacity(StringComparator, 100)
+    t2.Put("au", 61)
+    assertEqual(99, uint64(len(t2.spare)), t)
+    t2.ShrinkToFit()
+    assertEqual(0, uint64(len(t2.spare)), t)
+    True(t2.Has("au"), t)
+
+    // copies never share the preallocated block
+    t3 := t2.Filter(func(key, value interface{}) bool { return true })
+    assertEqual(0, uint64(len(t3.spare)), t)
+
+    // a hint is advisory
+    t4 := NewTreeWithCapacity(IntComparator, -1)
+    t4.Put(1, "payload1")
+    True(t4.Has(1), t)
+}
+
+func TestSameContent(t *testing.T) {
+    True(NewTree().SameContent(NewTree()), t)
+    False(NewTree().SameContent(nil), t)
+
+    keys, values := []interface{}{}, []interface{}{}
+    ascending, descending := NewTree(), NewTree()
+    for k := 1; k <= 20; k++ {
+        keys, values = append(keys, k), append(values, k*k)
+        ascending.Put(k, k*k)
+        descending.Put(21-k, (21-k)*(21-k))
+    }
+    bulk, _ := NewTreeFromSlices(IntComparator, keys, values)
+
+    True(ascending.SameContent(descending), t)
+    True(descending.SameContent(bulk), t)
+    True(bulk.SameContent(ascending), t)
+
+    bulk.Put(20, 0)
+    False(bulk.SameContent(ascending), t)
+    bulk.Put(20, 400)
+    True(bulk.SameContent(ascending), t)
+    bulk.Delete(20)
+    False(bulk.SameContent(ascending), t)
+    False(ascending.SameContent(bulk), t)
+
+    // payloads which are not comparable are never equal
+    t1, t2 := NewTree(), NewTree()
+    t1.Put(1, []int{1})
+    t2.Put(1, []int{1})
+    False(t1.SameContent(t2), t)
+    t1.Put(1, nil)
+    t2.Put(1, nil)
+    True(t1.SameContent(t2), t)
+}
+
+func TestOnOverwrite(t *testing.T) {
+    var calls []string
+    var t1 *Tree
+    t1 = NewTree(WithOnOverwrite(func(key, oldValue, newValue interface{}) {
+        // the hook runs before the payload is replaced
+        _, current := t1.Get(key)
+        assertPayloadString(oldValue.(string), current.(string), t)
+        calls = append(calls, oldValue.(string)+">"+newValue.(string))
+    }))
+    t1.Put(7, "a")
+    t1.Put(3, "b")
+    t1.Put(9, "c")
+    assertEqual(0, uint64(len(calls)), t)
+
+    t1.Put(7, "a+") // the root
+    t1.Put(3, "b+") // a left child
+    t1.Put(9, "c+") // a right child
+    if expected := []string{"a>a+", "b>b+", "c>c+"}; !reflect.DeepEqual(expected, calls) {
+        t.Errorf("Expected %q got %q", expected, calls)
+    }
+    _, payload := t1.Get(9)
+    assertPayloadString("c+", payload.(string), t)
+
+    // without a hook Put behaves as before
+    t2 := NewTree()
+    t2.Put(1, "a")
+    t2.Put(1, "b")
+    _, payload = t2.Get(1)
+    assertPayloadString("b", payload.(string), t)
+}
+
+var fixtureKthAfter = []struct {
+    key, k   int
+    expected int // 0 means none
+}{
+    {5, 1, 10},
+    {10, 1, 20},
+    {10, 2, 30},
+    {10, 4, 50},
+    {10, 5, 0},
+    {15, 3, 40},
+    {0, 5, 50},
+    {50, 1, 0},
+    {99, 1, 0},
+    {10, 0, 0},
+    {10, -1, 0},
+}
+
+func TestKthAfter(t *testing.T) {
+    t1 := NewTree()
+    _, ok := t1.KthAfter(1, 1)
+    False(ok, t)
+
+    for _, k := range []int{30, 10, 50, 20, 40} {
+        t1.Put(k, k*10)
+    }
+    for _, tt := range fixtureKthAfter {
+        entry, ok := t1.KthAfter(tt.key, tt.k)
+        if tt.expected == 0 {
+            False(ok, t)
+            Nil(entry, t)
+            continue
+        }
+        True(ok, t)
+        if entry.Key != tt.expected || entry.Value != tt.expected*10 {
+            t.Errorf("KthAfter(%d, %d): expected %d got %#v", tt.key, tt.k, tt.expected, entry)
+        }
+    }
+
+    // the successor of every key is the next one in order
+    for _, tt := range treeData {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    keys := t1.Keys()
+    _, n := t1.getNode(keys[0])
+    for i := 1; i < len(keys); i++ {
+        n = t1.successor(n)
+        True(n.key == keys[i], t)
+    }
+    Nil(t1.successor(n), t)
+}
+
+func TestInorderVisitorReset(t *testing.T) {
+    visitor := &InorderVisitor{}
+    visitor.Reset()
+    t1 := NewTree()
+    for _, tt := range fixtureSmall {
+        t1.Put(tt.kv.key, tt.kv.arg)
+        visitor.Reset()
+        t1.Walk(visitor)
+        assertPayloadString(tt.expected, visitor.String(), t)
+    }
+    visitor.Reset()
+    assertPayloadString("", visitor.String(), t)
+}
+
+func TestJSONL(t *testing.T) {
+    var buf bytes.Buffer
+    Nil(NewTree().WriteJSONL(&buf), t)
+    assertEqual(0, uint64(buf.Len()), t)
+
+    t1 := NewTree()
+    for _, k := range []int{3, -1, 2} {
+        t1.Put(k, "payload"+string(rune('0'+k+1)))
+    }
+    t1.Put(math.MaxInt32, 1.5)
+    Nil(t1.WriteJSONL(&buf), t)
+    expected := `{"key":-1,"value":"payload0"}
+{"key":2,"value":"payload3"}
+{"key":3,"value":"payload4"}
+{"key":2147483647,"value":1.5}
+`
+    assertPayloadString(expected, buf.String(), t)
+
+    t2, err := ReadJSONL(&buf, IntComparator)
+    Nil(err, t)
+    True(t1.SameContent(t2), t)
+    assertRedBlack(t2, t)
+
+    // unsorted lines are inserted one by one
+    t3, err := ReadJSONL(strings.NewReader("{\"key\":\"b\",\"value\":2}\n\n{\"key\":\"a\",\"value\":null}\n"), StringComparator)
+    Nil(err, t)
+    if expected := []interface{}{"a", "b"}; !reflect.DeepEqual(expected, t3.Keys()) {
+        t.Errorf("Expected %v got %v", expected, t3.Keys())
+    }
+    if expected := []interface{}{nil, 2}; !reflect.DeepEqual(expected, t3.Values()) {
+        t.Errorf("Expected %v got %v", expected, t3.Values())
+    }
+
+    // nested numbers & whole floats keep their types
+    nested := NewTree()
+    nested.Put(1, map[string]interface{}{"a": 1.5, "b": 2, "c": []interface{}{3, 4.0, "x"}})
+    nested.Put(2, 2.0)
+    nested.Put(3, []interface{}{1e21, -0.5})
+    buf.Reset()
+    Nil(nested.WriteJSONL(&buf), t)
+    restored, err := ReadJSONL(&buf, IntComparator)
+    Nil(err, t)
+    if !reflect.DeepEqual(nested.Values(), restored.Values()) {
+        t.Errorf("Expected %#v got %#v", nested.Values(), restored.Values())
+    }
+
+    _, err = ReadJSONL(strings.NewReader("{\"key\":"), IntComparator)
+    NotNil(err, t)
+    _, err = ReadJSONL(strings.NewReader("{\"key\":null}"), IntComparator)
+    if err != ErrorKeyIsNil {
+        t.Errorf("Expected %#v got %#v", ErrorKeyIsNil, err)
+    }
+}
+
+func TestComparatorFromLessFunc(t *testing.T) {
+    type person struct {
+        Name string
+        Age  int
+    }
+    people := []person{{"ann", 41}, {"bob", 27}, {"cat", 35}}
+    less := func(i, j int) bool { return people[i].Age < people[j].Age }
+    sort.Slice(people, less)
+
+    byAge := ComparatorFromLessFunc(func(a, b interface{}) bool {
+        return a.(person).Age < b.(person).Age
+    })
+    for _, tt := range fixtureComparator {
+        intLess := ComparatorFromLessFunc(func(a, b interface{}) bool {
+            return a.(int) < b.(int)
+        })
+        assertEqual(uint64(tt.expected), uint64(intLess(tt.op1, tt.op2)), t)
+    }
+
+    tr := NewTreeWith(byAge)
+    for _, p := range []person{{"cat", 35}, {"ann", 41}, {"bob", 27}} {
+        tr.Put(p, p.Name)
+    }
+    for i, key := range tr.Keys() {
+        True(key.(person) == people[i], t)
+    }
+    // same age means same key
+    tr.Put(person{"dan", 27}, "dan")
+    assertEqual(3, tr.Size(), t)
+    _, payload := tr.Get(person{"", 27})
+    assertPayloadString("dan", payload.(string), t)
+}
+
+func TestPathColors(t *testing.T) {
+    t1 := NewTree()
+    _, ok := t1.PathColors(1)
+    False(ok, t)
+
+    // ((.7.)8((.9.)10(.11.))), 10 being Black with Red children
+    for _, tt := range fixtureCase1 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    var fixture = []struct {
+        key      int
+        expected []Color
+    }{
+        {8, []Color{BLACK}},
+        {7, []Color{BLACK, BLACK}},
+        {10, []Color{BLACK, BLACK}},
+        {11, []Color{BLACK, BLACK, RED}},
+    }
+    for _, tt := range fixture {
+        colors, ok := t1.PathColors(tt.key)
+        True(ok, t)
+        if !reflect.DeepEqual(tt.expected, colors) {
+            t.Errorf("PathColors(%d): expected %v got %v", tt.key, tt.expected, colors)
+        }
+    }
+    colors, ok := t1.PathColors(12)
+    False(ok, t)
+    Nil(colors, t)
+}
+
+func TestSafeGet(t *testing.T) {
+    t1 := NewTree()
+    // nothing to compare with
+    _, ok, err := t1.SafeGet("7")
+    False(ok, t)
+    Nil(err, t)
+
+    t1.Put(7, "payload7")
+    value, ok, err := t1.SafeGet(7)
+    True(ok, t)
+    Nil(err, t)
+    assertPayloadString("payload7", value.(string), t)
+
+    value, ok, err = t1.SafeGet(8)
+    False(ok, t)
+    Nil(err, t)
+    Nil(value, t)
+
+    value, ok, err = t1.SafeGet("7")
+    False(ok, t)
+    Nil(value, t)
+    if err != ErrorKeyIncomparable {
+        t.Errorf("Expected %#v got %#v", ErrorKeyIncomparable, err)
+    }
+
+    _, _, err = t1.SafeGet(nil)
+    if err != ErrorKeyIsNil {
+        t.Errorf("Expected %#v got %#v", ErrorKeyIsNil, err)
+    }
+}
+
+func TestFloorCeilingValue(t *testing.T) {
+    t1 := NewTree()
+    _, ok := t1.FloorValue(1)
+    False(ok, t)
+    _, ok = t1.CeilingValue(1)
+    False(ok, t)
+
+    // thresholds mapped to configs
+    t1.Put(0, "low")
+    t1.Put(50, "mid")
+    t1.Put(90, "high")
+    var fixture = []struct {
+        key             int
+        floor, ceiling  string // empty means none
+    }{
+        {-1, "", "low"}, {0, "low", "low"}, {49, "low", "mid"}, {50, "mid", "mid"}, {89, "mid", "high"}, {120, "high", ""},
+    }
+    for _, tt := range fixture {
+        value, ok := t1.FloorValue(tt.key)
+        True(ok == (tt.floor != ""), t)
+        if ok {
+            assertPayloadString(tt.floor, value.(string), t)
+        }
+        value, ok = t1.CeilingValue(tt.key)
+        True(ok == (tt.ceiling != ""), t)
+        if ok {
+            assertPayloadString(tt.ceiling, value.(string), t)
+        }
+    }
+    _, ok = t1.FloorValue(nil)
+    False(ok, t)
+}
+
+func TestGoLiteral(t *testing.T) {
+    assertPayloadString(`withParents(&Tree{cmp: registered("int"), cmpName: "int"})`, NewTree().GoLiteral(), t)
+    assertPayloadString("withParents(&Tree{cmp: nil})", NewTreeWith(KeyComparator).GoLiteral(), t)
+    // only the name given at creation counts, not the function
+    assertPayloadString("withParents(&Tree{cmp: nil})", NewTreeWith(IntComparator).GoLiteral(), t)
+
+    t1 := NewTree()
+    for _, k := range []int{10, 5, 20, 15} {
+        t1.Put(k, k*2)
+    }
+    t1.Put(5, nil)
+    expected := `withParents(&Tree{cmp: registered("int"), cmpName: "int", root: &Node{key: 10, payload: 20, color: BLACK,
+    left: &Node{key: 5, color: BLACK},
+    right: &Node{key: 20, payload: 40, color: BLACK,
+        left: &Node{key: 15, payload: 30, color: RED}}}})`
+    assertPayloadString(expected, t1.GoLiteral(), t)
+
+    // pasted from the output above
+    t2 := withParents(&Tree{cmp: registered("int"), cmpName: "int", root: &Node{key: 10, payload: 20, color: BLACK,
+        left: &Node{key: 5, color: BLACK},
+        right: &Node{key: 20, payload: 40, color: BLACK,
+            left: &Node{key: 15, payload: 30, color: RED}}}})
+    True(t1.SameContent(t2), t)
+    assertPayloadString(t1.GoLiteral(), t2.GoLiteral(), t)
+    assertRedBlack(t2, t)
+
+    // the rebuilt tree behaves like the original
+    t1.Delete(5)
+    t2.Delete(5)
+    assertPayloadString(t1.GoLiteral(), t2.GoLiteral(), t)
+
+    s1, err := NewTreeNamed("string")
+    Nil(err, t)
+    s1.Put("au", "61")
+    assertPayloadString(`withParents(&Tree{cmp: registered("string"), cmpName: "string", root: &Node{key: "au", payload: "61", color: BLACK}})`, s1.GoLiteral(), t)
+
+    // custom comparators are found once registered
+    registerComparator(t, "case-insensitive", caseInsensitive)
+    c1, err := NewTreeNamed("case-insensitive")
+    Nil(err, t)
+    assertPayloadString(`withParents(&Tree{cmp: registered("case-insensitive"), cmpName: "case-insensitive"})`, c1.GoLiteral(), t)
+    c1 = withParents(&Tree{cmp: registered("case-insensitive")})
+    c1.Put("a", 1)
+    c1.Put("A", 2)
+    assertEqual(1, c1.Size(), t)
+}
+
+func TestPredicateVisitor(t *testing.T) {
+    odd := func(key, value interface{}) bool {
+        return key.(int)%2 == 1
+    }
+    visitor := NewPredicateVisitor(odd)
+    NewTree().Walk(visitor)
+    assertEqual(0, uint64(len(visitor.Results())), t)
+
+    t1 := NewTree()
+    for _, k := range []int{5, 2, 8, 1, 9, 4, 7} {
+        t1.Put(k, k*10)
+    }
+    t1.Walk(visitor)
+    expected := []Entry{{1, 10}, {5, 50}, {7, 70}, {9, 90}}
+    if actual := visitor.Results(); !reflect.DeepEqual(expected, actual) {
+        t.Errorf("Expected %v got %v", expected, actual)
+    }
+}
+
+func TestPartition(t *testing.T) {
+    t1 := NewTree()
+    Nil(t1.Partition(0), t)
+    chunks := t1.Partition(2)
+    assertEqual(2, uint64(len(chunks)), t)
+    assertEqual(0, uint64(len(chunks[0])+len(chunks[1])), t)
+
+    for k := 1; k <= 10; k++ {
+        t1.Put(k, k)
+    }
+    var fixture = []struct {
+        n     int
+        sizes []int
+    }{
+        {1, []int{10}},
+        {3, []int{4, 3, 3}},
+        {5, []int{2, 2, 2, 2, 2}},
+        {12, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0}},
+    }
+    for _, tt := range fixture {
+        chunks := t1.Partition(tt.n)
+        sizes := []int{}
+        next := 1
+        for _, chunk := range chunks {
+            sizes = append(sizes, len(chunk))
+            for _, entry := range chunk {
+                True(entry.Key.(int) == next, t)
+                next++
+            }
+        }
+        if !reflect.DeepEqual(tt.sizes, sizes) {
+            t.Errorf("Partition(%d): expected %v got %v", tt.n, tt.sizes, sizes)
+        }
+    }
+}
+
+// A comparator answering at random must not break the structure of the
+// tree, even though the order of its keys is then meaningless.
+func TestFlakyComparator(t *testing.T) {
+    r := rand.New(rand.NewSource(651))
+    flaky := func(o1, o2 interface{}) int {
+        return r.Intn(3) - 1
+    }
+    tr := NewTreeWith(flaky)
+    for i := 0; i < 2000; i++ {
+        if r.Intn(3) == 0 {
+            tr.Delete(r.Intn(100))
+        } else {
+            tr.Put(r.Intn(100), i)
+        }
+        if problems := tr.Diagnose(); len(problems) > 0 {
+            t.Fatalf("Corrupted tree after %d operations: %q", i+1, problems)
+        }
+    }
+    assertEqual(uint64(len(tr.Keys())), tr.Size(), t)
+}
+
+func TestLeftmostRightmostLeaf(t *testing.T) {
+    t1 := NewTree()
+    _, ok := t1.LeftmostLeaf()
+    False(ok, t)
+    _, ok = t1.RightmostLeaf()
+    False(ok, t)
+
+    t1.Put(7, 70)
+    left, _ := t1.LeftmostLeaf()
+    right, _ := t1.RightmostLeaf()
+    True(left.Key == 7 && right.Key == 7, t)
+
+    // ((.3(.5.))7(.9.)) by hand: the minimum 3 has a right child
+    t2 := withParents(&Tree{cmp: IntComparator, root: &Node{key: 7, payload: 70, color: BLACK,
+        left: &Node{key: 3, payload: 30, color: BLACK,
+            right: &Node{key: 5, payload: 50, color: RED}},
+        right: &Node{key: 9, payload: 90, color: BLACK}}})
+    left, ok = t2.LeftmostLeaf()
+    True(ok, t)
+    True(*left == Entry{5, 50}, t)
+    min, _ := t2.Min()
+    True(min.Key == 3, t)
+    right, ok = t2.RightmostLeaf()
+    True(ok, t)
+    True(*right == Entry{9, 90}, t)
+    max, _ := t2.Max()
+    True(max.Key == 9, t)
+}
+
+func TestRejectNilValue(t *testing.T) {
+    t1 := NewTree(RejectNilValue())
+    if err := t1.Put(1, nil); err != ErrorNilValue {
+        t.Errorf("Expected %#v got %#v", ErrorNilValue, err)
+    }
+    False(t1.Has(1), t)
+
+    Nil(t1.Put(1, "payload1"), t)
+    if err := t1.Put(1, nil); err != ErrorNilValue {
+        t.Errorf("Expected %#v got %#v", ErrorNilValue, err)
+    }
+    ok, payload := t1.Get(1)
+    True(ok, t)
+    assertPayloadString("payload1", payload.(string), t)
+
+    // a typed nil is a value
+    var p *int
+    Nil(t1.Put(2, p), t)
+
+    // permissive by default
+    t2 := NewTree()
+    Nil(t2.Put(1, nil), t)
+    ok, payload = t2.Get(1)
+    True(ok, t)
+    Nil(payload, t)
+
+    // bulk rewrites cannot slip nil payloads in either
+    t3 := NewTree(RejectNilValue())
+    t3.Put(1, "a")
+    t3.Put(2, "b")
+    t3.ReplaceAll(func(key, value interface{}) interface{} {
+        if key == 2 {
+            return nil
+        }
+        return "x"
+    })
+    ok, payload = t3.Get(1)
+    True(ok, t)
+    assertPayloadString("a", payload.(string), t)
+    t3.ReplaceAll(func(key, value interface{}) interface{} { return value.(string) + "!" })
+    ok, payload = t3.Get(2)
+    assertPayloadString("b!", payload.(string), t)
+
+    mapped := t3.Map(func(key, value interface{}) interface{} { return nil })
+    ok, payload = mapped.Get(1)
+    True(ok, t)
+    Nil(payload, t)
+    Nil(mapped.Put(3, nil), t)
+    mapped = t3.Map(func(key, value interface{}) interface{} { return value })
+    True(mapped.Put(3, nil) == ErrorNilValue, t)
+}
+
+func TestMultiGet(t *testing.T) {
+    t1 := NewTree()
+    assertEqual(0, uint64(len(t1.MultiGet([]interface{}{1, 2}))), t)
+
+    for _, k := range []int{5, 3, 8, 1} {
+        t1.Put(k, k*10)
+    }
+    actual := t1.MultiGet([]interface{}{8, 2, 1, nil, 8, 5})
+    expected := []Entry{{8, 80}, {1, 10}, {8, 80}, {5, 50}}
+    if !reflect.DeepEqual(expected, actual) {
+        t.Errorf("Expected %v got %v", expected, actual)
+    }
+    assertEqual(0, uint64(len(t1.MultiGet(nil))), t)
+}
+
+func TestNewTreeWith2(t *testing.T) {
+    type task struct {
+        Priority, Seq int
+    }
+    byPriority := func(o1, o2 interface{}) int {
+        return IntComparator(o1.(task).Priority, o2.(task).Priority)
+    }
+    bySeq := func(o1, o2 interface{}) int {
+        return IntComparator(o1.(task).Seq, o2.(task).Seq)
+    }
+    tasks := []task{{2, 1}, {1, 2}, {2, 3}, {1, 4}, {3, 5}}
+
+    tr := NewTreeWith2(byPriority, bySeq)
+    for _, tk := range tasks {
+        tr.Put(tk, tk.Seq)
+    }
+    assertEqual(5, tr.Size(), t)
+    expected := []interface{}{task{1, 2}, task{1, 4}, task{2, 1}, task{2, 3}, task{3, 5}}
+    if !reflect.DeepEqual(expected, tr.Keys()) {
+        t.Errorf("Expected %v got %v", expected, tr.Keys())
+    }
+    tr.Put(task{2, 3}, "again")
+    assertEqual(5, tr.Size(), t)
+
+    // without a tiebreak equal priorities collide
+    collide := NewTreeWith2(byPriority, nil)
+    for _, tk := range tasks {
+        collide.Put(tk, tk.Seq)
+    }
+    assertEqual(3, collide.Size(), t)
+}
+
+func TestWalkSubtree(t *testing.T) {
+    t1 := NewTree()
+    visitor := &InorderVisitor{}
+    False(t1.WalkSubtree(1, visitor), t)
+    assertPayloadString("", visitor.String(), t)
+
+    for _, tt := range fixtureCase1 {
+        t1.Put(tt.kv.key, tt.kv.arg)
+    }
+    // ((.7.)8((.9.)10(.11.)))
+    True(t1.WalkSubtree(10, visitor), t)
+    assertPayloadString("((.9.)10(.11.))", visitor.String(), t)
+
+    visitor.Reset()
+    True(t1.WalkSubtree(8, visitor), t)
+    assertPayloadString("((.7.)8((.9.)10(.11.)))", visitor.String(), t)
+
+    counter := &countingVisitor{}
+    True(t1.WalkSubtree(11, counter), t)
+    assertEqual(1, counter.Count, t)
+    False(t1.WalkSubtree(12, counter), t)
+    False(t1.WalkSubtree(nil, counter), t)
+}
+
+func TestBinary(t *testing.T) {
+    encode := func(v interface{}) ([]byte, error) {
+        return []byte(v.(string)), nil
+    }
+    decode := func(b []byte) (interface{}, error) {
+        return string(b), nil
+    }
+
+    var buf bytes.Buffer
+    Nil(NewTree().WriteBinary(&buf, encode), t)
+    if expected := []byte{0}; !bytes.Equal(expected, buf.Bytes()) {
+        t.Errorf("Expected %v got %v", expected, buf.Bytes())
+    }
+    empty, err := ReadBinary(&buf, IntComparator, decode)
+    Nil(err, t)
+    assertEqual(0, empty.Size(), t)
+
+    t1 := NewTree()
+    t1.Put(-1, "a")
+    t1.Put(300, "bc")
+    buf.Reset()
+    Nil(t1.WriteBinary(&buf, encode), t)
+    // count, key -1, "a", key 300, "bc"
+    expected := []byte{2, 1, 1, 'a', 0xd8, 0x04, 2, 'b', 'c'}
+    if !bytes.Equal(expected, buf.Bytes()) {
+        t.Errorf("Expected %v got %v", expected, buf.Bytes())
+    }
+
+    for k := 0; k < 1000; k++ {
+        t1.Put(k*7-3000, "payload")
+    }
+    buf.Reset()
+    Nil(t1.WriteBinary(&buf, encode), t)
+    t2, err := ReadBinary(bytes.NewReader(buf.Bytes()), IntComparator, decode)
+    Nil(err, t)
+    True(t1.SameContent(t2), t)
+    assertRedBlack(t2, t)
+
+    _, err = ReadBinary(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), IntComparator, decode)
+    if err != io.ErrUnexpectedEOF {
+        t.Errorf("Expected %#v got %#v", io.ErrUnexpectedEOF, err)
+    }
+
+    // a length of 2^63-1 bytes with nothing behind it
+    corrupt := []byte{1, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+    _, err = ReadBinary(bytes.NewReader(corrupt), IntComparator, decode)
+    if err != io.ErrUnexpectedEOF {
+        t.Errorf("Expected %#v got %#v", io.ErrUnexpectedEOF, err)
+    }
+    // a length of 2^64-1 bytes
+    corrupt = []byte{1, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}
+    _, err = ReadBinary(bytes.NewReader(corrupt), IntComparator, decode)
+    True(errors.Is(err, ErrorCorruptInput), t)
+    True(errors.Is(err, ErrorInvalidOperation), t)
+
+    s1 := NewTreeWith(StringComparator)
+    s1.Put("au", "61")
+    if err := s1.WriteBinary(&buf, encode); err != ErrorKeyNotInt {
+        t.Errorf("Expected %#v got %#v", ErrorKeyNotInt, err)
+    }
+}
+
+func TestBalance(t *testing.T) {
+    True(NewTree().Balance() == 1.0, t)
+
+    t1 := NewTree()
+    t1.Put(1, 1)
+    True(t1.Balance() == 1.0, t)
+    t1.Put(2, 2)
+    True(t1.Balance() == 1.0, t) // ideal height of 2 nodes is 2
+    t1.Put(3, 3)
+    True(t1.Balance() == 1.0, t)
+
+    // 9 ascending keys: height 4, ideal ⌈log2(10)⌉ = 4
+    for k := 4; k <= 9; k++ {
+        t1.Put(k, k)
+    }
+    True(t1.Balance() == 1.0, t)
+
+    // a chain of 3 nodes built by hand: height 3, ideal 2
+    chain := withParents(&Tree{cmp: IntComparator, root: &Node{key: 1, color: BLACK,
+        right: &Node{key: 2, color: BLACK,
+            right: &Node{key: 3, color: BLACK}}}})
+    True(chain.Balance() == 1.5, t)
+
+    r := rand.New(rand.NewSource(658))
+    t2 := NewTree()
+    for i := 0; i < 5000; i++ {
+        t2.Put(r.Int(), i)
+    }
+    balance := t2.Balance()
+    True(balance >= 1.0 && balance <= 2.0, t)
+}
+
+func TestIterator(t *testing.T) {
+    it := NewTree().Iterator()
+    False(it.HasNext(), t)
+    False(it.HasPrev(), t)
+    key, value := it.Next()
+    True(key == nil && value == nil, t)
+    key, value = it.Prev()
+    True(key == nil && value == nil, t)
+
+    t1 := NewTree()
+    for _, tt := range treeData {
+        t1.Put(tt.kv.key, tt.kv.key*10)
+    }
+    keys := t1.Keys()
+
+    // all the way forward
+    it = t1.Iterator()
+    for i := range keys {
+        True(it.HasNext(), t)
+        key, value := it.Next()
+        True(key == keys[i] && value == keys[i].(int)*10, t)
+    }
+    False(it.HasNext(), t)
+    key, _ = it.Next()
+    Nil(key, t)
+
+    // & all the way back
+    for i := len(keys) - 1; i >= 0; i-- {
+        True(it.HasPrev(), t)
+        key, _ := it.Prev()
+        True(key == keys[i], t)
+    }
+    False(it.HasPrev(), t)
+    key, _ = it.Prev()
+    Nil(key, t)
+
+    // paging forward & back resumes correctly at the start
+    key, _ = it.Next()
+    True(key == keys[0], t)
+    key, _ = it.Next()
+    True(key == keys[1], t)
+    key, _ = it.Prev()
+    True(key == keys[1], t)
+    key, _ = it.Prev()
+    True(key == keys[0], t)
+    False(it.HasPrev(), t)
+}
+
+var fixtureComparatorFloat64 = []struct {
+    op1, op2 float64
+    expected int
+}{
+    {0, 0, 0},
+    {-0.5, 0.5, -1},
+    {0.5, -0.5, 1},
+    {math.Inf(-1), -math.MaxFloat64, -1},
+    {math.MaxFloat64, math.Inf(1), -1},
+}
+
+func TestFloat64Comparator(t *testing.T) {
+    for _, tt := range fixtureComparatorFloat64 {
+        assertEqual(uint64(Float64Comparator(tt.op1, tt.op2)), uint64(tt.expected), t)
+    }
+}
+
+func TestNewTreeAuto(t *testing.T) {
+    t1 := NewTreeAuto()
+    False(t1.Has(1), t)
+    False(t1.HasInRange(1, 2), t)
+    if err := t1.Put(int64(1), "a"); err != ErrorKeyTypeUnsupported {
+        t.Errorf("Expected %#v got %#v", ErrorKeyTypeUnsupported, err)
+    }
+    if err := t1.Put(Key{"/", "au"}, "a"); err != ErrorKeyTypeUnsupported {
+        t.Errorf("Expected %#v got %#v", ErrorKeyTypeUnsupported, err)
+    }
+    assertEqual(0, t1.Size(), t)
+
+    // the first valid key locks in the comparator
+    Nil(t1.Put("b", 2), t)
+    Nil(t1.Put("a", 1), t)
+    if expected := []interface{}{"a", "b"}; !reflect.DeepEqual(expected, t1.Keys()) {
+        t.Errorf("Expected %v got %v", expected, t1.Keys())
+    }
+    mustPanic(func() { t1.Put(3, 3) }, t)
+
+    t2 := NewTreeAuto()
+    for _, k := range []int{3, 1, 2} {
+        Nil(t2.Put(k, k), t)
+    }
+    assertEqualTree(t2, t, "((.1.)2(.3.))")
+
+    t3 := NewTreeAuto(RejectNilValue())
+    Nil(t3.Put(2.5, "x"), t)
+    Nil(t3.Put(-1.0, "y"), t)
+    min, _ := t3.Min()
+    True(min.Key == -1.0, t)
+    True(t3.Put(0.0, nil) == ErrorNilValue, t)
+}
+
+func TestOpCounters(t *testing.T) {
+    t1 := NewTree()
+    t1.Put(1, "a")
+    t1.Put(1, "b")
+    assertEqual(0, t1.OpCounters().Inserts, t)
+    assertEqual(0, t1.OpCounters().Overwrites, t)
+
+    t2 := NewTree(WithOpCounters())
+    for _, k := range []int{1, 2, 3, 2, 4} {
+        t2.Put(k, k)
+    }
+    t2.Delete(3)
+    t2.Delete(5)
+    counters := t2.OpCounters()
+    assertEqual(4, counters.Inserts, t)
+    assertEqual(1, counters.Overwrites, t)
+    assertEqual(1, counters.Deletes, t)
+    // inserting 3 in ascending order rotates left of 1
+    True(counters.Rotations >= 1, t)
+
+    // the returned value is a copy
+    counters.Inserts = 100
+    assertEqual(4, t2.OpCounters().Inserts, t)
+
+    t2.ResetCounters()
+    if (t2.OpCounters() != OpCounters{}) {
+        t.Errorf("Expected zero counters got %+v", t2.OpCounters())
+    }
+    t2.Put(9, 9)
+    assertEqual(1, t2.OpCounters().Inserts, t)
+
+    // trees derived from t2 count on their own
+    t3 := t2.Filter(func(key, value interface{}) bool { return true })
+    t3.Put(10, 10)
+    assertEqual(1, t2.OpCounters().Inserts, t)
+    assertEqual(1, t3.OpCounters().Inserts, t)
+}
+
+func ExampleTree_Bucket() {
+    // seconds since the last request => allowed burst
+    limits := NewTree()
+    limits.Put(1, 1)
+    limits.Put(10, 5)
+    limits.Put(60, 20)
+
+    for _, elapsed := range []int{0, 1, 7, 45, 90} {
+        if boundary, burst, ok := limits.Bucket(elapsed); ok {
+            fmt.Printf("%d => bucket %v, burst %v\n", elapsed, boundary, burst)
+        } else {
+            fmt.Printf("%d => no bucket\n", elapsed)
+        }
+    }
+    // Output:
+    // 0 => bucket 1, burst 1
+    // 1 => bucket 1, burst 1
+    // 7 => bucket 10, burst 5
+    // 45 => bucket 60, burst 20
+    // 90 => no bucket
+}
+
+var fixtureRootSide = []struct {
+    key      int
+    expected Direction
+}{
+    {1, LEFT},
+    {2, NODIR},
+    {3, RIGHT},
+    {-5, LEFT},
+}
+
+func TestRootSide(t *testing.T) {
+    tr := NewTree()
+    dir, ok := tr.RootSide(1)
+    False(ok, t)
+    True(dir == NODIR, t)
+
+    for _, k := range []int{1, 2, 3} {
+        tr.Put(k, k)
+    }
+    assertEqualTree(tr, t, "((.1.)2(.3.))")
+    for _, tt := range fixtureRootSide {
+        dir, ok := tr.RootSide(tt.key)
+        True(ok, t)
+        if dir != tt.expected {
+            t.Errorf("RootSide(%d): expected %s got %s", tt.key, tt.expected, dir)
+        }
+    }
+    _, ok = tr.RootSide(nil)
+    False(ok, t)
+}
+
+func TestSnapshotKeys(t *testing.T) {
+    tr := NewTree()
+    for i := 0; i < 20; i++ {
+        tr.Put(i, i)
+    }
+    for i, key := range tr.SnapshotKeys() {
+        if i%2 == 0 {
+            tr.Delete(key)
+        } else {
+            tr.Put(key.(int)+100, key)
+        }
+    }
+    assertEqual(20, tr.Size(), t)
+    assertRedBlack(tr, t)
+    expected := []interface{}{}
+    for i := 1; i < 20; i += 2 {
+        expected = append(expected, i)
+    }
+    for i := 101; i < 120; i += 2 {
+        expected = append(expected, i)
+    }
+    if keys := tr.Keys(); !reflect.DeepEqual(expected, keys) {
+        t.Errorf("Expected %v got %v", expected, keys)
+    }
+    assertEqual(0, uint64(len(NewTree().SnapshotKeys())), t)
+}
+
+func TestMinMax(t *testing.T) {
+    tr := NewTree()
+    _, _, _, _, ok := tr.MinMax()
+    False(ok, t)
+
+    tr.Put(5, "five")
+    minKey, minVal, maxKey, maxVal, ok := tr.MinMax()
+    True(ok, t)
+    True(minKey == 5 && maxKey == 5, t)
+    True(minVal == "five" && maxVal == "five", t)
+
+    for _, k := range []int{9, -3, 7, 0} {
+        tr.Put(k, strconv.Itoa(k))
+    }
+    minKey, minVal, maxKey, maxVal, ok = tr.MinMax()
+    True(ok, t)
+    True(minKey == -3 && minVal == "-3", t)
+    True(maxKey == 9 && maxVal == "9", t)
+}
+
+type event struct {
+    Timestamp int
+    Name      string
+}
+
+func ExampleComparatorByField() {
+    byTimestamp := ComparatorByField(func(o interface{}) interface{} {
+        return o.(event).Timestamp
+    }, IntComparator)
+
+    tr := NewTreeWith(byTimestamp)
+    tr.Put(event{30, "stop"}, nil)
+    tr.Put(event{10, "start"}, nil)
+    tr.Put(event{20, "pause"}, nil)
+    for _, key := range tr.Keys() {
+        fmt.Printf("%d %s\n", key.(event).Timestamp, key.(event).Name)
+    }
+    // Output:
+    // 10 start
+    // 20 pause
+    // 30 stop
+}
+
+func ExampleComparatorFromLessFunc() {
+    events := []event{{30, "stop"}, {10, "start"}, {20, "pause"}}
+
+    // before: sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+    byName := ComparatorFromLessFunc(func(a, b interface{}) bool {
+        return a.(event).Name < b.(event).Name
+    })
+
+    tr := NewTreeWith(byName)
+    for _, e := range events {
+        tr.Put(e, e.Timestamp)
+    }
+    for _, key := range tr.Keys() {
+        fmt.Println(key.(event).Name)
+    }
+    // Output:
+    // pause
+    // start
+    // stop
+}
+
+func TestRepairColors(t *testing.T) {
+    // every node red, parents missing
+    allRed := &Tree{cmp: IntComparator, root: &Node{key: 2, color: RED,
+        left: &Node{key: 1, color: RED}, right: &Node{key: 3, color: RED}}}
+    True(len(allRed.Diagnose()) > 0, t)
+    Nil(allRed.RepairColors(), t)
+    assertRedBlack(allRed, t)
+    assertEqualTree(allRed, t, "((.1.)2(.3.))")
+
+    // a right spine has no valid coloring & is rebuilt
+    spine := &Tree{cmp: IntComparator, root: &Node{key: 1, payload: "a",
+        right: &Node{key: 2, payload: "b", right: &Node{key: 3, payload: "c", right: &Node{key: 4, payload: "d"}}}}}
+    Nil(spine.RepairColors(), t)
+    assertRedBlack(spine, t)
+    if expected := []interface{}{"a", "b", "c", "d"}; !reflect.DeepEqual(expected, spine.Values()) {
+        t.Errorf("Expected %v got %v", expected, spine.Values())
+    }
+
+    unordered := &Tree{cmp: IntComparator, root: &Node{key: 2, left: &Node{key: 3}}}
+    before := unordered.GoLiteral()
+    True(unordered.RepairColors() == ErrorKeysOutOfOrder, t)
+    assertPayloadString(before, unordered.GoLiteral(), t)
+
+    Nil(NewTree().RepairColors(), t)
+
+    // scrambled colors on a valid shape are repaired in place
+    r := rand.New(rand.NewSource(667))
+    tr := NewTree()
+    for i := 0; i < 200; i++ {
+        tr.Put(r.Intn(1000), i)
+    }
+    healthy := tr.GoLiteral()
+    Nil(tr.RepairColors(), t)
+    assertPayloadString(healthy, tr.GoLiteral(), t)
+
+    shape := &InorderVisitor{}
+    tr.Walk(shape)
+    inorder(tr.root, func(n *Node) bool {
+        n.color = Color(r.Intn(2) == 0)
+        return true
+    })
+    Nil(tr.RepairColors(), t)
+    assertRedBlack(tr, t)
+    assertEqualTree(tr, t, shape.String())
+}
+
+func TestExtractRange(t *testing.T) {
+    tr := NewTree()
+    for i := 1; i <= 50; i++ {
+        tr.Put(i, i*10)
+    }
+    extracted := tr.ExtractRange(10, 19)
+    assertEqual(10, uint64(len(extracted)), t)
+    for i, entry := range extracted {
+        True(entry.Key == 10+i, t)
+        True(entry.Value == (10+i)*10, t)
+    }
+    assertEqual(40, tr.Size(), t)
+    False(tr.HasInRange(10, 19), t)
+    True(tr.Has(9) && tr.Has(20), t)
+    assertRedBlack(tr, t)
+
+    assertEqual(0, uint64(len(tr.ExtractRange(30, 20))), t)
+    assertEqual(0, uint64(len(tr.ExtractRange(10, 19))), t)
+    assertEqual(40, tr.Size(), t)
+
+    // migrate into another tree
+    keys, values := []interface{}{}, []interface{}{}
+    for _, entry := range tr.ExtractRange(math.MinInt64, 5) {
+        keys = append(keys, entry.Key)
+        values = append(values, entry.Value)
+    }
+    other, err := NewTreeFromSlices(IntComparator, keys, values)
+    Nil(err, t)
+    assertEqualTree(other, t, "((.1(.2.))3(.4(.5.)))")
+    assertEqual(35, tr.Size(), t)
+    assertRedBlack(tr, t)
+}
+
+var fixtureRankOf = []struct {
+    key      int
+    expected uint64
+}{
+    {-1, 0},
+    {0, 0},
+    {5, 1},
+    {10, 1},
+    {11, 2},
+    {40, 4},
+    {41, 5},
+}
+
+func TestRankOf(t *testing.T) {
+    assertEqual(0, NewTree().RankOf(3), t)
+
+    tr := NewTree()
+    for _, k := range []int{20, 0, 40, 10, 30} {
+        tr.Put(k, k)
+    }
+    for _, tt := range fixtureRankOf {
+        if rank := tr.RankOf(tt.key); rank != tt.expected {
+            t.Errorf("RankOf(%d): expected %d got %d", tt.key, tt.expected, rank)
+        }
+    }
+    assertEqual(0, tr.RankOf(nil), t)
+}
+
+var fixtureSurrounding = []struct {
+    key            int
+    floor, ceiling interface{}
+}{
+    {5, nil, 10},
+    {10, 10, 10},
+    {15, 10, 20},
+    {30, 30, 30},
+    {35, 30, nil},
+}
+
+func TestSurrounding(t *testing.T) {
+    floor, ceiling := NewTree().Surrounding(1)
+    True(floor == nil && ceiling == nil, t)
+
+    tr := NewTree()
+    for _, k := range []int{20, 10, 30} {
+        tr.Put(k, k*2)
+    }
+    for _, tt := range fixtureSurrounding {
+        floor, ceiling := tr.Surrounding(tt.key)
+        if tt.floor == nil {
+            True(floor == nil, t)
+        } else {
+            True(floor.Key == tt.floor && floor.Value == tt.floor.(int)*2, t)
+        }
+        if tt.ceiling == nil {
+            True(ceiling == nil, t)
+        } else {
+            True(ceiling.Key == tt.ceiling && ceiling.Value == tt.ceiling.(int)*2, t)
+        }
+    }
+}
+
+// seqVisitor collects the sequence number of every node, in key order.
+type seqVisitor struct {
+    seqs []uint64
+}
+
+func (v *seqVisitor) Visit(node *Node) {
+    if isNil(node) {
+        return
+    }
+    v.Visit(node.left)
+    v.seqs = append(v.seqs, node.Seq())
+    v.Visit(node.right)
+}
+
+func TestInsertionSeq(t *testing.T) {
+    _, ok := NewTree(WithInsertionSeq(false)).Oldest()
+    False(ok, t)
+
+    plain := NewTree()
+    plain.Put(1, 1)
+    v := &seqVisitor{}
+    plain.Walk(v)
+    assertEqual(0, v.seqs[0], t)
+
+    keep := NewTree(WithInsertionSeq(false))
+    refresh := NewTree(WithInsertionSeq(true))
+    for _, tr := range []*Tree{keep, refresh} {
+        for _, k := range []int{30, 10, 20, 40} {
+            tr.Put(k, k)
+        }
+        tr.Put(30, "again")
+    }
+
+    v = &seqVisitor{}
+    keep.Walk(v)
+    if expected := []uint64{2, 3, 1, 4}; !reflect.DeepEqual(expected, v.seqs) {
+        t.Errorf("Expected %v got %v", expected, v.seqs)
+    }
+    oldest, _ := keep.Oldest()
+    True(oldest.Key == 30 && oldest.Value == "again", t)
+    newest, _ := keep.Newest()
+    True(newest.Key == 40, t)
+
+    v = &seqVisitor{}
+    refresh.Walk(v)
+    if expected := []uint64{2, 3, 5, 4}; !reflect.DeepEqual(expected, v.seqs) {
+        t.Errorf("Expected %v got %v", expected, v.seqs)
+    }
+    oldest, _ = refresh.Oldest()
+    True(oldest.Key == 10, t)
+    newest, _ = refresh.Newest()
+    True(newest.Key == 30, t)
+
+    // evict the oldest inserted keys, rotations & deletes keep the stamps
+    refresh.Delete(10)
+    oldest, _ = refresh.Oldest()
+    True(oldest.Key == 20, t)
+    refresh.Put(50, 50)
+    refresh.Delete(20)
+    oldest, _ = refresh.Oldest()
+    True(oldest.Key == 40, t)
+
+    // only trees asking for sequence numbers pay for them
+    unstamped := NewTree()
+    unstamped.Put(1, 1)
+    Nil(unstamped.root.meta, t)
+    assertEqual(nodeOverheadBytes, unstamped.ApproxMemoryBytes(nil), t)
+    stamped := NewTree(WithInsertionSeq(false))
+    stamped.Put(1, 1)
+    NotNil(stamped.root.meta, t)
+    assertEqual(nodeOverheadBytes+nodeMetaBytes, stamped.ApproxMemoryBytes(nil), t)
+    tracked := NewTree(WithAccessTracking())
+    tracked.Put(1, 1)
+    NotNil(tracked.root.meta, t)
+}
+
+func TestPage(t *testing.T) {
+    entries, cursor, more := NewTree().Page(nil, 10)
+    assertEqual(0, uint64(len(entries)), t)
+    True(cursor == nil, t)
+    False(more, t)
+
+    tr := NewTree()
+    for i := 1; i <= 10; i++ {
+        tr.Put(i, i)
+    }
+
+    var cursors []interface{}
+    var pages [][]interface{}
+    cursor, more = nil, true
+    for more {
+        entries, cursor, more = tr.Page(cursor, 4)
+        keys := []interface{}{}
+        for _, entry := range entries {
+            keys = append(keys, entry.Key)
+        }
+        pages = append(pages, keys)
+        cursors = append(cursors, cursor)
+    }
+    if expected := [][]interface{}{{1, 2, 3, 4}, {5, 6, 7, 8}, {9, 10}}; !reflect.DeepEqual(expected, pages) {
+        t.Errorf("Expected %v got %v", expected, pages)
+    }
+    if expected := []interface{}{4, 8, 10}; !reflect.DeepEqual(expected, cursors) {
+        t.Errorf("Expected %v got %v", expected, cursors)
+    }
+
+    // an exact multiple of the limit
+    entries, cursor, more = tr.Page(5, 5)
+    assertEqual(5, uint64(len(entries)), t)
+    True(cursor == 10, t)
+    False(more, t)
+
+    // the cursor survives the deletion of its key
+    tr.Delete(4)
+    entries, cursor, more = tr.Page(4, 2)
+    True(entries[0].Key == 5 && entries[1].Key == 6, t)
+    True(cursor == 6 && more, t)
+
+    // a limit < 1 ends the paging loop instead of spinning
+    entries, cursor, more = tr.Page(3, 0)
+    assertEqual(0, uint64(len(entries)), t)
+    True(cursor == 3 && !more, t)
+    _, _, more = tr.Page(nil, -1)
+    False(more, t)
+
+    entries, cursor, more = tr.Page(10, 3)
+    assertEqual(0, uint64(len(entries)), t)
+    True(cursor == 10, t)
+    False(more, t)
+}
+
+func reverseIntComparator(o1, o2 interface{}) int {
+    return IntComparator(o2, o1)
+}
+
+// registerComparator registers `c` under `name` for the duration of the
+// test only.
+func registerComparator(t *testing.T, name string, c Comparator) {
+    RegisterComparator(name, c)
+    t.Cleanup(func() {
+        registryLock.Lock()
+        defer registryLock.Unlock()
+        delete(comparators, name)
+    })
+}
+
+func TestComparatorRegistry(t *testing.T) {
+    for name, expected := range map[string]Comparator{"int": IntComparator, "string": StringComparator, "float64": Float64Comparator} {
+        c, ok := LookupComparator(name)
+        True(ok, t)
+        True(reflect.ValueOf(c).Pointer() == reflect.ValueOf(expected).Pointer(), t)
+        actual, ok := ComparatorName(expected)
+        True(ok, t)
+        assertPayloadString(name, actual, t)
+    }
+    _, ok := LookupComparator("reverse-int")
+    False(ok, t)
+    _, ok = ComparatorName(reverseIntComparator)
+    False(ok, t)
+    _, ok = ComparatorName(nil)
+    False(ok, t)
+
+    registerComparator(t, "reverse-int", reverseIntComparator)
+    name, ok := ComparatorName(reverseIntComparator)
+    True(ok, t)
+    assertPayloadString("reverse-int", name, t)
+
+    // a function registered twice has no single name
+    registerComparator(t, "descending", reverseIntComparator)
+    _, ok = ComparatorName(reverseIntComparator)
+    False(ok, t)
+
+    // closures of one function share their code, so none is ever named
+    byLen := ComparatorByField(func(o interface{}) interface{} { return len(o.(string)) }, IntComparator)
+    first := ComparatorByField(func(o interface{}) interface{} { return int(o.(string)[0]) }, IntComparator)
+    registerComparator(t, "byLen", byLen)
+    _, ok = ComparatorName(byLen)
+    False(ok, t)
+    _, ok = ComparatorName(first)
+    False(ok, t)
+    _, ok = NewTreeWith(first).ComparatorName()
+    False(ok, t)
+    named, err := NewTreeNamed("byLen")
+    Nil(err, t)
+    name, ok = named.ComparatorName()
+    True(ok, t)
+    assertPayloadString("byLen", name, t)
+    _, err = NewTreeNamed("missing")
+    True(err == ErrorUnknownComparator, t)
+    // a transformed ordering is not the registered one
+    transformed, err := NewTreeNamed("string", WithKeyTransformer(func(k interface{}) interface{} { return k }))
+    Nil(err, t)
+    _, ok = transformed.ComparatorName()
+    False(ok, t)
+
+    // save the name along with the content & restore both
+    tr, err := NewTreeNamed("reverse-int")
+    Nil(err, t)
+    for _, k := range []int{1, 3, 2} {
+        tr.Put(k, k)
+    }
+    var buf bytes.Buffer
+    Nil(tr.WriteJSONL(&buf), t)
+    name, ok = tr.ComparatorName()
+    True(ok, t)
+    c, ok := LookupComparator(name)
+    True(ok, t)
+    restored, err := ReadJSONL(&buf, c)
+    Nil(err, t)
+    True(tr.SameContent(restored), t)
+    if expected := []interface{}{3, 2, 1}; !reflect.DeepEqual(expected, restored.Keys()) {
+        t.Errorf("Expected %v got %v", expected, restored.Keys())
+    }
+}
+
+func TestIndexOf(t *testing.T) {
+    tr := NewTree()
+    for _, k := range rand.New(rand.NewSource(674)).Perm(100) {
+        tr.Put(k, k)
+    }
+    for k := 0; k < 100; k++ {
+        _, n := tr.getNode(k)
+        index, err := tr.IndexOf(n)
+        Nil(err, t)
+        assertEqual(uint64(k), index, t)
+    }
+
+    _, n := tr.getNode(50)
+    tr.Delete(50)
+    _, err := tr.IndexOf(n)
+    True(err == ErrorNodeNotInTree, t)
+
+    other := NewTree()
+    other.Put(1, 1)
+    _, n = tr.getNode(1)
+    _, err = other.IndexOf(n)
+    True(err == ErrorNodeNotInTree, t)
+    _, err = other.IndexOf(other.root)
+    Nil(err, t)
+    _, err = other.IndexOf(nil)
+    True(err == ErrorNodeNotInTree, t)
+}
+
+// caseInsensitive makes "a" & "A" equal keys.
+func caseInsensitive(o1, o2 interface{}) int {
+    return StringComparator(strings.ToLower(o1.(string)), strings.ToLower(o2.(string)))
+}
+
+func TestNewTreeFromSorted(t *testing.T) {
+    keys := []interface{}{"a", "A", "b", "c", "C", "c"}
+    values := []interface{}{1, 2, 3, 4, 5, 6}
+
+    last, err := NewTreeFromSorted(caseInsensitive, keys, values, KeepLast)
+    Nil(err, t)
+    assertRedBlack(last, t)
+    if expected := []interface{}{"A", "b", "c"}; !reflect.DeepEqual(expected, last.Keys()) {
+        t.Errorf("Expected %v got %v", expected, last.Keys())
+    }
+    if expected := []interface{}{2, 3, 6}; !reflect.DeepEqual(expected, last.Values()) {
+        t.Errorf("Expected %v got %v", expected, last.Values())
+    }
+
+    first, err := NewTreeFromSorted(caseInsensitive, keys, values, KeepFirst)
+    Nil(err, t)
+    if expected := []interface{}{"a", "b", "c"}; !reflect.DeepEqual(expected, first.Keys()) {
+        t.Errorf("Expected %v got %v", expected, first.Keys())
+    }
+    if expected := []interface{}{1, 3, 4}; !reflect.DeepEqual(expected, first.Values()) {
+        t.Errorf("Expected %v got %v", expected, first.Values())
+    }
+
+    _, err = NewTreeFromSorted(caseInsensitive, keys, values, RejectDuplicates)
+    True(err == ErrorDuplicateKey, t)
+    unique, err := NewTreeFromSorted(IntComparator, []interface{}{1, 2, 3}, []interface{}{1, 2, 3}, RejectDuplicates)
+    Nil(err, t)
+    assertEqualTree(unique, t, "((.1.)2(.3.))")
+
+    _, err = NewTreeFromSorted(IntComparator, []interface{}{2, 1}, []interface{}{2, 1}, KeepLast)
+    True(err == ErrorKeysOutOfOrder, t)
+    _, err = NewTreeFromSorted(IntComparator, []interface{}{1}, []interface{}{}, KeepLast)
+    True(err == ErrorLengthMismatch, t)
+    empty, err := NewTreeFromSorted(IntComparator, nil, nil, KeepLast)
+    Nil(err, t)
+    assertEqual(0, empty.Size(), t)
+}
+
+func TestRekey(t *testing.T) {
+    tr := NewTree()
+    for _, k := range []int{30, 10, 20, 40} {
+        tr.Put(k, k)
+    }
+    tr.Rekey(func(k interface{}) interface{} { return k.(int) + 1000 })
+    assertEqualTree(tr, t, "((.1010.)1020(.1030(.1040.)))")
+    True(tr.Has(1040), t)
+    False(tr.Has(40), t)
+    ok, payload := tr.Get(1010)
+    True(ok && payload == 10, t)
+    assertRedBlack(tr, t)
+
+    tr.Rekey(func(k interface{}) interface{} { return nil })
+    assertEqualTree(tr, t, "((.1010.)1020(.1030(.1040.)))")
+
+    NewTree().Rekey(func(k interface{}) interface{} { return k })
+
+    checked := NewTree(WithComparatorCheck())
+    for _, k := range []int{1, 2, 3} {
+        checked.Put(k, k)
+    }
+    mustPanic(func() { checked.Rekey(func(k interface{}) interface{} { return -k.(int) }) }, t)
+    assertEqualTree(checked, t, "((.1.)2(.3.))")
+}
+
+var fixtureRangeWalkDescending = []struct {
+    lo, hi   int
+    limit    int
+    expected []interface{}
+}{
+    {3, 7, 10, []interface{}{7, 6, 5, 4, 3}},
+    {3, 7, 2, []interface{}{7, 6}},
+    {-5, 2, 10, []interface{}{2, 1, 0}},
+    {8, 100, 10, []interface{}{9, 8}},
+    {7, 3, 10, []interface{}{}},
+    {20, 30, 10, []interface{}{}},
+    {4, 4, 10, []interface{}{4}},
+}
+
+func TestRangeWalkDescending(t *testing.T) {
+    tr := NewTree()
+    for _, k := range rand.New(rand.NewSource(677)).Perm(10) {
+        tr.Put(k, k*k)
+    }
+    for _, tt := range fixtureRangeWalkDescending {
+        keys := []interface{}{}
+        tr.RangeWalkDescending(tt.lo, tt.hi, func(key, value interface{}) bool {
+            True(value == key.(int)*key.(int), t)
+            keys = append(keys, key)
+            return len(keys) < tt.limit
+        })
+        if !reflect.DeepEqual(tt.expected, keys) {
+            t.Errorf("[%d,%d]: expected %v got %v", tt.lo, tt.hi, tt.expected, keys)
+        }
+    }
+
+    var comparisons int
+    counting := NewTreeWith(func(o1, o2 interface{}) int {
+        comparisons++
+        return IntComparator(o1, o2)
+    })
+    for i := 0; i < 1024; i++ {
+        counting.Put(i, i)
+    }
+    comparisons = 0
+    counting.RangeWalkDescending(1000, 1002, func(key, value interface{}) bool { return true })
+    True(comparisons < 100, t)
+}
+
+func TestCheckConnectivity(t *testing.T) {
+    Nil(NewTree().CheckConnectivity(), t)
+
+    tr := NewTree()
+    for i := 0; i < 50; i++ {
+        tr.Put(i, i)
+    }
+    Nil(tr.CheckConnectivity(), t)
+
+    // the manual construction of main, without parent links
+    node1 := Node{key: 10, left: &Node{key: 8}, right: &Node{key: 11}}
+    manual := &Tree{cmp: IntComparator, root: &Node{key: 7, left: &Node{key: 3}, right: &Node{key: 18, left: &node1}}}
+    err := manual.CheckConnectivity()
+    True(errors.Is(err, ErrorBrokenLink), t)
+    assertPayloadString("Inconsistent link between nodes: node 3 has parent nil instead of 7", err.Error(), t)
+    Nil(withParents(manual).CheckConnectivity(), t)
+
+    node1.left.parent = manual.root
+    err = manual.CheckConnectivity()
+    assertPayloadString("Inconsistent link between nodes: node 8 has parent 7 instead of 10", err.Error(), t)
+    node1.left.parent = &node1
+
+    // a cycle back to the root
+    node1.right.right = manual.root
+    manual.root.parent = node1.right
+    err = manual.CheckConnectivity()
+    assertPayloadString("Inconsistent link between nodes: root 7 has parent 11", err.Error(), t)
+    manual.root.parent = nil
+    err = manual.CheckConnectivity()
+    assertPayloadString("Inconsistent link between nodes: node 7 has parent nil instead of 11", err.Error(), t)
+
+    // a subtree shared by two parents
+    node1.right.right = nil
+    shared := &Node{key: 9, parent: node1.left}
+    node1.left.right = shared
+    manual.root.left.right = shared
+    err = manual.CheckConnectivity()
+    assertPayloadString("Inconsistent link between nodes: node 9 has parent 8 instead of 3", err.Error(), t)
+
+    // the same child on both sides
+    twin := &Node{key: 2}
+    double := withParents(&Tree{cmp: IntComparator, root: &Node{key: 1, left: twin, right: twin}})
+    err = double.CheckConnectivity()
+    assertPayloadString("Inconsistent link between nodes: node 2 is reached twice", err.Error(), t)
+}
+
+func TestBulkLoadChecks(t *testing.T) {
+    b := NewBuilder(IntComparator, RejectNilValue()).BulkSorted([]Entry{{1, "a"}, {2, nil}})
+    True(b.Err() == ErrorNilValue, t)
+    assertEqual(0, b.Build().Size(), t)
+
+    b = NewBuilder(IntComparator, WithSameKeyKind()).BulkSorted([]Entry{{1, "a"}, {int64(2), "b"}})
+    True(b.Err() == ErrorKeyKindMismatch, t)
+
+    withNil := NewTree()
+    withNil.Put(1, nil)
+    strict := NewTree(RejectNilValue())
+    True(strict.AttachSubtree(withNil) == ErrorNilValue, t)
+    ok, _ := strict.Get(1)
+    False(ok, t)
+
+    // bulk loads count & stamp like Puts, in key order
+    counted := NewTree(WithOpCounters(), WithInsertionSeq(false))
+    source := NewTree()
+    for _, k := range []int{3, 1, 2} {
+        source.Put(k, k)
+    }
+    Nil(counted.AttachSubtree(source), t)
+    assertEqual(3, counted.OpCounters().Inserts, t)
+    oldest, _ := counted.Oldest()
+    newest, _ := counted.Newest()
+    True(oldest.Key == 1 && newest.Key == 3, t)
+}
+
+func TestBuilder(t *testing.T) {
+    b := NewBuilder(IntComparator).Put(3, "c").Put(1, "a").Put(2, "b").Put(2, "B")
+    Nil(b.Err(), t)
+    tr := b.Build()
+    assertEqualTree(tr, t, "((.1.)2(.3.))")
+    ok, payload := tr.Get(2)
+    True(ok && payload == "B", t)
+
+    entries := []Entry{}
+    for i := 1; i <= 7; i++ {
+        entries = append(entries, Entry{Key: i, Value: i})
+    }
+    bulk := NewBuilder(IntComparator, WithOpCounters()).BulkSorted(entries).Put(8, 8).Build()
+    assertEqualTree(bulk, t, "(((.1.)2(.3.))4((.5.)6(.7(.8.))))")
+    assertEqual(8, bulk.OpCounters().Inserts, t)
+    assertRedBlack(bulk, t)
+
+    // not empty, so entries are put in turn
+    mixed := NewBuilder(IntComparator).Put(0, 0).BulkSorted(entries[:3]).Build()
+    assertEqual(4, mixed.Size(), t)
+    assertRedBlack(mixed, t)
+
+    failed := NewBuilder(IntComparator).Put(1, 1).Put(nil, 2).Put(3, 3)
+    True(failed.Err() == ErrorKeyIsNil, t)
+    assertEqual(1, failed.Build().Size(), t)
+    failed = NewBuilder(IntComparator).BulkSorted([]Entry{{Key: 1}, {Key: []int{}}})
+    True(failed.Err() == ErrorKeyDisallowed, t)
+    assertEqual(0, failed.Build().Size(), t)
+}
+
+func TestPollFirst(t *testing.T) {
+    _, ok := NewTree().PollFirst()
+    False(ok, t)
+
+    r := rand.New(rand.NewSource(680))
+    tr := NewTree()
+    shadow := map[int]bool{}
+    for round := 0; round < 2000; round++ {
+        switch r.Intn(4) {
+        case 0, 1:
+            k := r.Intn(500)
+            tr.Put(k, k)
+            shadow[k] = true
+        case 2:
+            entry, ok := tr.PollFirst()
+            if len(shadow) == 0 {
+                False(ok, t)
+                continue
+            }
+            True(ok, t)
+            for k := range shadow {
+                True(entry.Key.(int) <= k, t)
+            }
+            delete(shadow, entry.Key.(int))
+        case 3:
+            k := r.Intn(500)
+            tr.Delete(k)
+            delete(shadow, k)
+        }
+        min, okMin := tr.Min()
+        max, okMax := tr.Max()
+        True(okMin == (len(shadow) > 0) && okMax == okMin, t)
+        if okMin {
+            True(min.Key == tr.getMinimum(tr.root).key, t)
+            True(max.Key == tr.getMaximum(tr.root).key, t)
+        }
+    }
+    assertEqual(uint64(len(shadow)), tr.Size(), t)
+
+    tr = NewTree()
+    for _, k := range []int{5, 1, 9} {
+        tr.Put(k, k)
+    }
+    last, _ := tr.PollLast()
+    True(last.Key == 9, t)
+    max, _ := tr.Max()
+    True(max.Key == 5, t)
+
+    // trees derived in bulk have their own extremes
+    filtered := tr.Filter(func(key, value interface{}) bool { return key != 1 })
+    min, _ := filtered.Min()
+    True(min.Key == 5, t)
+    min, _ = tr.Min()
+    True(min.Key == 1, t)
+}
+
+func TestComparatorsAgree(t *testing.T) {
+    samples := []interface{}{3, -1, 7, 0, 3}
+    scaled := func(o1, o2 interface{}) int { return 10 * IntComparator(o1, o2) }
+    True(ComparatorsAgree(IntComparator, scaled, samples), t)
+    False(ComparatorsAgree(IntComparator, reverseIntComparator, samples), t)
+    True(ComparatorsAgree(IntComparator, reverseIntComparator, []interface{}{4, 4}), t)
+    True(ComparatorsAgree(IntComparator, reverseIntComparator, nil), t)
+
+    byLength := ComparatorByField(func(o interface{}) interface{} { return len(o.(string)) }, IntComparator)
+    True(ComparatorsAgree(StringComparator, byLength, []interface{}{"a", "bb", "ccc"}), t)
+    False(ComparatorsAgree(StringComparator, byLength, []interface{}{"a", "bb", "b"}), t)
+}
+
+func TestDeleteIf(t *testing.T) {
+    tr := NewTree()
+    for i := 0; i < 20; i++ {
+        tr.Put(i, i%3)
+    }
+    calls := 0
+    stale := func(value interface{}) bool {
+        calls++
+        return value == 0
+    }
+    False(tr.DeleteIf(100, stale), t)
+    assertEqual(0, uint64(calls), t)
+    False(tr.DeleteIf(nil, stale), t)
+
+    for i := 0; i < 20; i++ {
+        True(tr.DeleteIf(i, stale) == (i%3 == 0), t)
+    }
+    assertEqual(20, uint64(calls), t)
+    assertEqual(13, tr.Size(), t)
+    assertRedBlack(tr, t)
+    for i := 0; i < 20; i++ {
+        True(tr.Has(i) == (i%3 != 0), t)
+    }
+}
+
+func TestPutIf(t *testing.T) {
+    tr := NewTree(WithOpCounters())
+    newer := func(version int) func(old interface{}, existed bool) bool {
+        return func(old interface{}, existed bool) bool {
+            return !existed || old.(int) < version
+        }
+    }
+    True(tr.PutIf(1, 5, newer(5)), t)
+    False(tr.PutIf(1, 3, newer(3)), t)
+    True(tr.PutIf(1, 7, newer(7)), t)
+    ok, payload := tr.Get(1)
+    True(ok && payload == 7, t)
+
+    onlyInsert := func(old interface{}, existed bool) bool {
+        Nil(old, t)
+        return !existed
+    }
+    for _, k := range []int{2, 3, 4} {
+        True(tr.PutIf(k, k, onlyInsert), t)
+    }
+    True(tr.PutIf(0, 0, onlyInsert), t)
+    assertRedBlack(tr, t)
+
+    never := func(old interface{}, existed bool) bool { return false }
+    False(tr.PutIf(9, 9, never), t)
+    False(tr.PutIf(2, 9, never), t)
+    False(NewTree().PutIf(9, 9, never), t)
+    False(tr.Has(9), t)
+    assertEqual(5, tr.OpCounters().Inserts, t)
+    assertEqual(1, tr.OpCounters().Overwrites, t)
+
+    False(tr.PutIf(nil, 1, func(old interface{}, existed bool) bool {
+        t.Errorf("cond called on an invalid key")
+        return true
+    }), t)
+}
+
+func TestHeadTail(t *testing.T) {
+    assertEqual(0, uint64(len(NewTree().Head(3))), t)
+    assertEqual(0, uint64(len(NewTree().Tail(3))), t)
+
+    tr := NewTree()
+    for _, k := range []int{5, 2, 8, 1, 9, 3} {
+        tr.Put(k, k)
+    }
+    if expected := []interface{}{1, 2, 3}; !reflect.DeepEqual(expected, entryKeys(tr.Head(3))) {
+        t.Errorf("Expected %v got %v", expected, entryKeys(tr.Head(3)))
+    }
+    if expected := []interface{}{5, 8, 9}; !reflect.DeepEqual(expected, entryKeys(tr.Tail(3))) {
+        t.Errorf("Expected %v got %v", expected, entryKeys(tr.Tail(3)))
+    }
+    if !reflect.DeepEqual(tr.Keys(), entryKeys(tr.Head(100))) || !reflect.DeepEqual(tr.Keys(), entryKeys(tr.Tail(100))) {
+        t.Errorf("Expected all of %v", tr.Keys())
+    }
+    assertEqual(0, uint64(len(tr.Head(0))), t)
+    assertEqual(0, uint64(len(tr.Tail(-1))), t)
+    True(tr.Tail(1)[0].Value == 9, t)
+}
+
+func TestWithKeyTransformer(t *testing.T) {
+    lower := WithKeyTransformer(func(key interface{}) interface{} { return strings.ToLower(key.(string)) })
+    tr := NewTreeWith(StringComparator, lower)
+    for _, k := range []string{"Banana", "apple", "Cherry"} {
+        tr.Put(k, k)
+    }
+    // ordered by lowercase form, "Banana" < "apple" otherwise
+    if expected := []interface{}{"apple", "Banana", "Cherry"}; !reflect.DeepEqual(expected, tr.Keys()) {
+        t.Errorf("Expected %v got %v", expected, tr.Keys())
+    }
+    ok, payload := tr.Get("BANANA")
+    True(ok && payload == "Banana", t)
+
+    // the original key is kept on overwrite
+    tr.Put("APPLE", "overwritten")
+    assertEqual(3, tr.Size(), t)
+    min, _ := tr.Min()
+    True(min.Key == "apple" && min.Value == "overwritten", t)
+    tr.Delete("cherry")
+    False(tr.Has("Cherry"), t)
+
+    auto := NewTreeAuto(lower)
+    Nil(auto.Put("b", 1), t)
+    Nil(auto.Put("A", 2), t)
+    if expected := []interface{}{"A", "b"}; !reflect.DeepEqual(expected, auto.Keys()) {
+        t.Errorf("Expected %v got %v", expected, auto.Keys())
+    }
+
+    abs := WithKeyTransformer(func(key interface{}) interface{} {
+        if i := key.(int); i < 0 {
+            return -i
+        }
+        return key
+    })
+    signed := NewTreeAuto(abs)
+    for _, k := range []int{-3, 2, -1} {
+        signed.Put(k, k)
+    }
+    if expected := []interface{}{-1, 2, -3}; !reflect.DeepEqual(expected, signed.Keys()) {
+        t.Errorf("Expected %v got %v", expected, signed.Keys())
+    }
+}
+
+func TestEdges(t *testing.T) {
+    assertEqual(0, uint64(len(NewTree().Edges())), t)
+    single := NewTree()
+    single.Put(1, 1)
+    assertEqual(0, uint64(len(single.LabeledEdges())), t)
+
+    tr := NewTree()
+    for _, k := range []int{2, 1, 4, 3, 5} {
+        tr.Put(k, k)
+    }
+    assertEqualTree(tr, t, "((.1.)2((.3.)4(.5.)))")
+    expected := []Edge{
+        {Parent: 2, Child: 1, Side: LEFT},
+        {Parent: 2, Child: 4, Side: RIGHT},
+        {Parent: 4, Child: 3, Side: LEFT},
+        {Parent: 4, Child: 5, Side: RIGHT},
+    }
+    if edges := tr.LabeledEdges(); !reflect.DeepEqual(expected, edges) {
+        t.Errorf("Expected %v got %v", expected, edges)
+    }
+    pairs := [][2]interface{}{{2, 1}, {2, 4}, {4, 3}, {4, 5}}
+    if edges := tr.Edges(); !reflect.DeepEqual(pairs, edges) {
+        t.Errorf("Expected %v got %v", pairs, edges)
+    }
+}
+
+func TestEqualGroup(t *testing.T) {
+    type event struct {
+        Timestamp, Seq int
+    }
+    byTimestamp := func(o1, o2 interface{}) int {
+        return IntComparator(o1.(event).Timestamp, o2.(event).Timestamp)
+    }
+    bySeq := func(o1, o2 interface{}) int {
+        return IntComparator(o1.(event).Seq, o2.(event).Seq)
+    }
+
+    tr := NewTreeWith2(byTimestamp, bySeq)
+    for seq := 0; seq < 60; seq++ {
+        tr.Put(event{seq % 4, 100 - seq}, seq)
+    }
+    group := tr.EqualGroup(event{Timestamp: 2})
+    assertEqual(15, uint64(len(group)), t)
+    for i, entry := range group {
+        True(entry.Key.(event).Timestamp == 2, t)
+        if i > 0 {
+            True(entry.Key.(event).Seq > group[i-1].Key.(event).Seq, t)
+        }
+    }
+    assertEqual(0, uint64(len(tr.EqualGroup(event{Timestamp: 9}))), t)
+
+    plain := NewTree()
+    plain.Put(1, "a")
+    plain.Put(2, "b")
+    group = plain.EqualGroup(2)
+    assertEqual(1, uint64(len(group)), t)
+    True(group[0].Value == "b", t)
+    assertEqual(0, uint64(len(plain.EqualGroup(3))), t)
+}
+
+func TestDetachAttachSubtree(t *testing.T) {
+    tr := NewTree()
+    for i := 1; i <= 31; i++ {
+        tr.Put(i, i)
+    }
+    _, ok := tr.DetachSubtree(100)
+    False(ok, t)
+
+    left := tr.root.left.key
+    detached, ok := tr.DetachSubtree(left)
+    True(ok, t)
+    assertRedBlack(tr, t)
+    assertRedBlack(detached, t)
+    assertEqual(31, tr.Size()+detached.Size(), t)
+    for i := 1; i <= 31; i++ {
+        True(tr.Has(i) != detached.Has(i), t)
+    }
+    True(detached.Has(left), t)
+
+    other := NewTree()
+    other.Put(1000, 1000)
+    Nil(tr.AttachSubtree(detached), t)
+    Nil(tr.AttachSubtree(other), t)
+    assertEqual(32, tr.Size(), t)
+    assertRedBlack(tr, t)
+    True(detached.Has(left), t)
+
+    True(tr.AttachSubtree(other) == ErrorDuplicateKey, t)
+    assertEqual(32, tr.Size(), t)
+
+    // detaching the root empties the tree
+    whole, ok := tr.DetachSubtree(tr.root.key)
+    True(ok, t)
+    assertEqual(0, tr.Size(), t)
+    Nil(tr.AttachSubtree(whole), t)
+    assertEqual(32, tr.Size(), t)
+    assertRedBlack(tr, t)
+
+    // keys in descending order are not bulk-loaded into an ascending tree
+    reversed := NewTreeWith(reverseIntComparator)
+    for i := 1; i <= 10; i++ {
+        reversed.Put(i, i)
+    }
+    ascending := NewTree()
+    Nil(ascending.AttachSubtree(reversed), t)
+    assertEqual(10, ascending.Size(), t)
+    assertRedBlack(ascending, t)
+    True(ascending.IsSorted(), t)
+    for i := 1; i <= 10; i++ {
+        True(ascending.Has(i), t)
+    }
+
+    // an auto tree chooses its comparator from the first attached key
+    for _, size := range []int{1, 5} {
+        auto := NewTreeAuto()
+        source := NewTree()
+        for i := 1; i <= size; i++ {
+            source.Put(i, i)
+        }
+        Nil(auto.AttachSubtree(source), t)
+        assertEqual(uint64(size), auto.Size(), t)
+        ok, v := auto.Get(size)
+        True(ok && v == size, t)
+        assertRedBlack(auto, t)
+    }
+    floats := NewTreeWith(Float64Comparator)
+    floats.Put(float32(1), 1)
+    auto := NewTreeAuto()
+    True(auto.AttachSubtree(floats) == ErrorKeyTypeUnsupported, t)
+    True(auto.cmp == nil, t)
+    Nil(auto.Put("a", 1), t)
+
+    // a rejected entry part-way leaves t unchanged
+    strict := NewTree(RejectNilValue())
+    strict.Put(0, 0)
+    partial := NewTree()
+    partial.Put(1, 1)
+    partial.Put(2, nil)
+    True(strict.AttachSubtree(partial) == ErrorNilValue, t)
+    assertEqual(1, strict.Size(), t)
+}
+
+func TestDeleteWithoutSibling(t *testing.T) {
+    // hand-built & unbalanced: the black leaf 1 has no sibling
+    left := NewTreeWith(IntComparator)
+    left.root = &Node{key: 2, color: BLACK, left: &Node{key: 1, color: BLACK}}
+    left.root.left.parent = left.root
+    left.Delete(1)
+    False(left.Has(1), t)
+    True(left.Has(2), t)
+
+    right := NewTreeWith(IntComparator)
+    right.root = &Node{key: 1, color: BLACK, right: &Node{key: 2, color: BLACK}}
+    right.root.right.parent = right.root
+    right.Delete(2)
+    False(right.Has(2), t)
+    True(right.Has(1), t)
+    True(right.leaf().color == BLACK, t)
+}
+
+func TestNilSafeComparator(t *testing.T) {
+    one, two := 1, 2
+    byPointee := NilSafeComparator(func(o1, o2 interface{}) int {
+        return IntComparator(*o1.(*int), *o2.(*int))
+    })
+    var nilInt *int
+    assertEqual(0, uint64(byPointee(nilInt, nilInt)), t)
+    assertEqual(0, uint64(byPointee(nil, nilInt)), t)
+    True(byPointee(nilInt, &one) < 0, t)
+    True(byPointee(&one, nilInt) > 0, t)
+    True(byPointee(nil, &one) < 0, t)
+    True(byPointee(&two, &one) > 0, t)
+    assertEqual(0, uint64(byPointee(&one, &one)), t)
+
+    // a struct key with an optional part
+    type version struct {
+        Name  string
+        Patch *int
+    }
+    tr := NewTreeWith2(
+        ComparatorByField(func(o interface{}) interface{} { return o.(version).Name }, StringComparator),
+        ComparatorByField(func(o interface{}) interface{} { return o.(version).Patch }, byPointee))
+    for _, v := range []version{{"b", &two}, {"a", &one}, {"b", nil}, {"a", nil}, {"b", &one}} {
+        Nil(tr.Put(v, nil), t)
+    }
+    expected := []interface{}{version{"a", nil}, version{"a", &one}, version{"b", nil}, version{"b", &one}, version{"b", &two}}
+    if !reflect.DeepEqual(expected, tr.Keys()) {
+        t.Errorf("Expected %v got %v", expected, tr.Keys())
+    }
+}
+
+func TestShapeString(t *testing.T) {
+    assertPayloadString(".", NewTree().ShapeString(), t)
+
+    tr := NewTree()
+    for _, k := range []int{1, 2, 3} {
+        tr.Put(k, k)
+    }
+    assertPayloadString("((.1{R}.)2{B}(.3{R}.))", tr.ShapeString(), t)
+    tr.Put(4, 4)
+    assertPayloadString("((.1{B}.)2{B}(.3{B}(.4{R}.)))", tr.ShapeString(), t)
+    tr.Delete(1)
+    assertPayloadString("((.2{B}.)3{B}(.4{B}.))", tr.ShapeString(), t)
+
+    // same content, different shapes
+    words := NewTreeWith(StringComparator)
+    other := NewTreeWith(StringComparator)
+    for _, w := range []string{"a", "b", "c", "d"} {
+        words.Put(w, nil)
+    }
+    for _, w := range []string{"d", "c", "b", "a"} {
+        other.Put(w, nil)
+    }
+    True(words.SameContent(other), t)
+    assertPayloadString("((.a{B}.)b{B}(.c{B}(.d{R}.)))", words.ShapeString(), t)
+    assertPayloadString("(((.a{R}.)b{B}.)c{B}(.d{B}.))", other.ShapeString(), t)
+}
+
+// The sentinel leaf is told apart by its nil key only, so the zero value
+// of a key type must behave like any other key.
+func TestZeroValueKeys(t *testing.T) {
+    ints := NewTree()
+    for _, k := range []int{1, 0, -1} {
+        ints.Put(k, k)
+    }
+    ok, payload := ints.Get(0)
+    True(ok && payload == 0, t)
+    assertEqualTree(ints, t, "((.-1.)0(.1.))")
+    ints.Delete(0)
+    False(ints.Has(0), t)
+    assertEqual(2, ints.Size(), t)
+    assertRedBlack(ints, t)
+
+    strs := NewTreeWith(StringComparator)
+    strs.Put("", "empty")
+    strs.Put("a", "a")
+    min, _ := strs.Min()
+    True(min.Key == "" && min.Value == "empty", t)
+    ok, payload = strs.Get("")
+    True(ok && payload == "empty", t)
+    strs.Delete("")
+    False(strs.Has(""), t)
+    assertEqual(1, strs.Size(), t)
+
+    type point struct{ X, Y int }
+    structs := NewTreeAuto()
+    True(structs.Put(point{}, 1) == ErrorKeyTypeUnsupported, t)
+    set := NewSet()
+    set.Add(0)
+    True(set.Contains(0), t)
+    set.Remove(0)
+    False(set.Contains(0), t)
+}
+
+func TestPrefixScan(t *testing.T) {
+    tr := NewTreeWith(StringComparator)
+    for i, w := range []string{"car", "cart", "carbon", "cat", "ca", "dog", "c", "care"} {
+        tr.Put(w, i)
+    }
+    scan := func(prefix string, limit int) []string {
+        keys := []string{}
+        tr.PrefixScan(prefix, func(key string, value interface{}) bool {
+            keys = append(keys, key)
+            return len(keys) < limit
+        })
+        return keys
+    }
+    if expected := []string{"car", "carbon", "care", "cart"}; !reflect.DeepEqual(expected, scan("car", 10)) {
+        t.Errorf("Expected %v got %v", expected, scan("car", 10))
+    }
+    if expected := []string{"c", "ca"}; !reflect.DeepEqual(expected, scan("c", 2)) {
+        t.Errorf("Expected %v got %v", expected, scan("c", 2))
+    }
+    assertEqual(0, uint64(len(scan("cab", 10))), t)
+    assertEqual(0, uint64(len(scan("z", 10))), t)
+    assertEqual(8, uint64(len(scan("", 10))), t)
+    tr.PrefixScan("c", nil)
+}
+
+func TestGetAndDelete(t *testing.T) {
+    tr := NewTree(WithOpCounters())
+    for i := 0; i < 10; i++ {
+        tr.Put(i, i*i)
+    }
+    payload, ok := tr.GetAndDelete(3)
+    True(ok && payload == 9, t)
+    False(tr.Has(3), t)
+
+    payload, ok = tr.GetAndDelete(3)
+    False(ok, t)
+    Nil(payload, t)
+    payload, ok = tr.GetAndDelete(nil)
+    False(ok, t)
+    assertEqual(9, tr.Size(), t)
+    assertEqual(1, tr.OpCounters().Deletes, t)
+
+    for i := 0; i < 10; i++ {
+        tr.GetAndDelete(i)
+        assertRedBlack(tr, t)
+    }
+    assertEqual(0, tr.Size(), t)
+}
+
+func TestLeastRecentlyUsed(t *testing.T) {
+    _, ok := NewTree(WithAccessTracking()).LeastRecentlyUsed()
+    False(ok, t)
+
+    lru := NewTree(WithAccessTracking())
+    for _, k := range []int{1, 2, 3, 4} {
+        lru.Put(k, k)
+    }
+    entry, _ := lru.LeastRecentlyUsed()
+    True(entry.Key == 1, t)
+
+    lru.Get(1)
+    lru.Put(2, "again")
+    lru.Has(3) // not an access
+    entry, _ = lru.LeastRecentlyUsed()
+    True(entry.Key == 3, t)
+
+    // evict down to 2 entries
+    for lru.Size() > 2 {
+        entry, _ = lru.LeastRecentlyUsed()
+        lru.Delete(entry.Key)
+    }
+    if expected := []interface{}{1, 2}; !reflect.DeepEqual(expected, lru.Keys()) {
+        t.Errorf("Expected %v got %v", expected, lru.Keys())
+    }
+    entry, _ = lru.LeastRecentlyUsed()
+    True(entry.Key == 1, t)
+}
+
+func TestClone(t *testing.T) {
+    tr := NewTree(WithInsertionSeq(false))
+    for i := 0; i < 20; i++ {
+        tr.Put(i, i)
+    }
+    c := tr.Clone()
+    assertPayloadString(tr.ShapeString(), c.ShapeString(), t)
+    assertRedBlack(c, t)
+    oldest, _ := c.Oldest()
+    True(oldest.Key == 0, t)
+
+    c.Delete(0)
+    c.Put(100, 100)
+    True(tr.Has(0), t)
+    False(tr.Has(100), t)
+    assertRedBlack(c, t)
+    assertEqual(0, NewTree().Clone().Size(), t)
+}
+
+func TestSyncTreeForEachSnapshot(t *testing.T) {
+    st := NewSyncTree(IntComparator)
+    for i := 0; i < 100; i++ {
+        Nil(st.Put(i, i), t)
+    }
+
+    var wg sync.WaitGroup
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        for i := 100; i < 200; i++ {
+            st.Put(i, i)
+        }
+    }()
+    go func() {
+        defer wg.Done()
+        for i := 0; i < 50; i++ {
+            st.Delete(i)
+        }
+    }()
+
+    // writes during the iteration, even from fn itself, are not seen
+    previous, visited := -1, uint64(0)
+    st.ForEachSnapshot(func(key, value interface{}) bool {
+        True(key.(int) > previous && key.(int) < 1000, t)
+        previous = key.(int)
+        visited++
+        st.Put(key.(int)+1000, nil)
+        return true
+    })
+    wg.Wait()
+    assertEqual(150+visited, st.Size(), t)
+
+    True(st.PutIf(7, "x", func(old interface{}, existed bool) bool { return !existed }), t)
+    payload, ok := st.GetAndDelete(7)
+    True(ok && payload == "x", t)
+    ok, _ = st.Get(7)
+    False(ok, t)
+
+    count := 0
+    st.ForEachSnapshot(func(key, value interface{}) bool {
+        count++
+        return count < 3
+    })
+    assertEqual(3, uint64(count), t)
+}
+
+func TestDepthHistogram(t *testing.T) {
+    assertEqual(0, uint64(len(NewTree().DepthHistogram())), t)
+
+    tr := NewTree()
+    for i := 1; i <= 7; i++ {
+        tr.Put(i, i)
+    }
+    assertEqualTree(tr, t, "((.1.)2((.3.)4((.5.)6(.7.))))")
+    if expected := map[int]uint64{0: 1, 1: 2, 2: 2, 3: 2}; !reflect.DeepEqual(expected, tr.DepthHistogram()) {
+        t.Errorf("Expected %v got %v", expected, tr.DepthHistogram())
+    }
+
+    var total uint64
+    for _, count := range tr.DepthHistogram() {
+        total += count
+    }
+    assertEqual(tr.Size(), total, t)
+}
+
+// versionComparator orders "major.minor" strings numerically.
+func versionComparator(o1, o2 interface{}) (int, error) {
+    var parsed [2][2]int
+    for i, o := range []interface{}{o1, o2} {
+        parts := strings.Split(o.(string), ".")
+        if len(parts) != 2 {
+            return 0, fmt.Errorf("malformed version %q", o)
+        }
+        for j, part := range parts {
+            n, err := strconv.Atoi(part)
+            if err != nil {
+                return 0, err
+            }
+            parsed[i][j] = n
+        }
+    }
+    if c := IntComparator(parsed[0][0], parsed[1][0]); c != 0 {
+        return c, nil
+    }
+    return IntComparator(parsed[0][1], parsed[1][1]), nil
+}
+
+func TestComparatorE(t *testing.T) {
+    tr := NewTreeWithE(versionComparator)
+    for _, v := range []string{"1.10", "1.2", "2.0"} {
+        Nil(tr.PutE(v, v), t)
+    }
+    if expected := []interface{}{"1.2", "1.10", "2.0"}; !reflect.DeepEqual(expected, tr.Keys()) {
+        t.Errorf("Expected %v got %v", expected, tr.Keys())
+    }
+    before := tr.ShapeString()
+
+    err := tr.PutE("1.x", "bad")
+    NotNil(err, t)
+    assertPayloadString(before, tr.ShapeString(), t)
+    _, ok, err := tr.GetE("3")
+    False(ok, t)
+    assertPayloadString(`malformed version "3"`, err.Error(), t)
+    NotNil(tr.DeleteE("two"), t)
+    assertEqual(3, tr.Size(), t)
+
+    value, ok, err := tr.GetE("1.10")
+    Nil(err, t)
+    True(ok && value == "1.10", t)
+    Nil(tr.DeleteE("1.10"), t)
+    Nil(tr.DeleteE("9.9"), t)
+    assertEqual(2, tr.Size(), t)
+
+    // the plain methods panic, other panics pass through the E methods
+    mustPanic(func() { tr.Get("bad") }, t)
+    plain := NewTree()
+    plain.Put(1, 1)
+    mustPanic(func() { plain.PutE("1", 1) }, t)
+}
+
+func TestRetain(t *testing.T) {
+    assertEqual(0, NewTree().Retain(1, 2), t)
+
+    fill := func() *Tree {
+        tr := NewTree()
+        for i := 0; i < 100; i++ {
+            tr.Put(i, i)
+        }
+        return tr
+    }
+    tr := fill()
+    assertEqual(60, tr.Retain(20, 59), t)
+    assertEqual(40, tr.Size(), t)
+    min, _ := tr.Min()
+    max, _ := tr.Max()
+    True(min.Key == 20 && max.Key == 59, t)
+    assertRedBlack(tr, t)
+    assertEqual(0, tr.Retain(-10, 200), t)
+
+    tr = fill()
+    assertEqual(100, tr.Retain(60, 20), t)
+    assertEqual(0, tr.Size(), t)
+
+    tr = fill()
+    assertEqual(100, tr.Retain(200, 300), t)
+    tr = fill()
+    assertEqual(99, tr.Retain(50, 50), t)
+    True(tr.Has(50), t)
+    assertRedBlack(tr, t)
+}
+
+func TestRotationCount(t *testing.T) {
+    assertEqual(0, RotationCount(IntComparator, nil), t)
+    assertEqual(0, RotationCount(IntComparator, []interface{}{2, 1, 3}), t)
+    assertEqual(1, RotationCount(IntComparator, []interface{}{1, 2, 3}), t)
+    assertEqual(1, RotationCount(IntComparator, []interface{}{1, 2, nil, 3}), t)
+
+    sorted, shuffled := []interface{}{}, []interface{}{}
+    for _, k := range rand.New(rand.NewSource(699)).Perm(1000) {
+        sorted = append(sorted, len(sorted))
+        shuffled = append(shuffled, k)
+    }
+    True(RotationCount(IntComparator, sorted) > RotationCount(IntComparator, shuffled), t)
+}
+
+// compactBlackHeight checks the red-black properties & parent links of
+// the subtree at x & returns its black height.
+func compactBlackHeight(tr *CompactIntTree, x int32, t *testing.T) int {
+    if x == 0 {
+        return 1
+    }
+    n := tr.nodes[x]
+    for _, child := range []int32{n.left, n.right} {
+        if child != 0 && tr.nodes[child].parent != x {
+            t.Fatalf("node %d is not the parent of %d", n.key, tr.nodes[child].key)
+        }
+        if n.color == RED && tr.nodes[child].color == RED {
+            t.Fatalf("Red node %d has a Red child", n.key)
+        }
+    }
+    left, right := compactBlackHeight(tr, n.left, t), compactBlackHeight(tr, n.right, t)
+    if left != right {
+        t.Fatalf("node %d has black heights %d & %d", n.key, left, right)
+    }
+    if n.color == BLACK {
+        return left + 1
+    }
+    return left
+}
+
+func TestCompactIntTree(t *testing.T) {
+    tr := NewCompactIntTree(16)
+    _, ok := tr.Min()
+    False(ok, t)
+    ok, _ = tr.Get(0)
+    False(ok, t)
+    tr.Delete(0)
+
+    r := rand.New(rand.NewSource(700))
+    shadow := map[int]int{}
+    for round := 0; round < 5000; round++ {
+        k := r.Intn(300)
+        if r.Intn(3) == 0 {
+            tr.Delete(k)
+            delete(shadow, k)
+        } else {
+            Nil(tr.Put(k, round), t)
+            shadow[k] = round
+        }
+        if round%100 == 0 {
+            True(tr.nodes[tr.root].color == BLACK, t)
+            compactBlackHeight(tr, tr.root, t)
+        }
+    }
+    assertEqual(uint64(len(shadow)), tr.Size(), t)
+    for k, v := range shadow {
+        ok, payload := tr.Get(k)
+        True(ok && payload == v, t)
+    }
+    keys := tr.Keys()
+    True(sort.IntsAreSorted(keys) && len(keys) == len(shadow), t)
+    min, _ := tr.Min()
+    max, _ := tr.Max()
+    True(min.Key == keys[0] && max.Key == keys[len(keys)-1], t)
+
+    // freed slots are reused
+    slots := len(tr.nodes)
+    for _, k := range keys {
+        tr.Delete(k)
+    }
+    assertEqual(0, tr.Size(), t)
+    for i := 0; i < len(keys); i++ {
+        tr.Put(i, i)
+    }
+    assertEqual(uint64(slots), uint64(len(tr.nodes)), t)
+    compactBlackHeight(tr, tr.root, t)
+
+    count := 0
+    tr.Each(func(key int, value interface{}) bool {
+        count++
+        return count < 5
+    })
+    assertEqual(5, uint64(count), t)
+
+    // no index is handed out past the limit, freed slots still are
+    defer func(max int) { compactMaxIndex = max }(compactMaxIndex)
+    compactMaxIndex = 2
+    tr = NewCompactIntTree(0)
+    Nil(tr.Put(-3, -3), t)
+    Nil(tr.Put(-1, -1), t)
+    err := tr.Put(-2, -2)
+    True(err == ErrorTreeFull && errors.Is(err, ErrorInvalidOperation), t)
+    False(tr.Has(-2), t)
+    Nil(tr.Put(-1, 0), t)
+    tr.Delete(-1)
+    Nil(tr.Put(-2, -2), t)
+    True(tr.Has(-2), t)
+}
+
+const benchmarkKeys = 1 << 16
+
+func BenchmarkTreeGet(b *testing.B) {
+    tr := NewTree(WithCapacity(benchmarkKeys))
+    for i := 0; i < benchmarkKeys; i++ {
+        tr.Put(i, i)
+    }
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        tr.Get(i * 7919 % benchmarkKeys)
+    }
+}
+
+func BenchmarkCompactIntTreeGet(b *testing.B) {
+    tr := NewCompactIntTree(benchmarkKeys)
+    for i := 0; i < benchmarkKeys; i++ {
+        tr.Put(i, i)
+    }
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        tr.Get(i * 7919 % benchmarkKeys)
+    }
+}
+
+func TestIsSorted(t *testing.T) {
+    True(NewTree().IsSorted(), t)
+    tr := NewTree()
+    for _, k := range rand.New(rand.NewSource(701)).Perm(50) {
+        tr.Put(k, k)
+    }
+    True(tr.IsSorted(), t)
+
+    unordered := &Tree{cmp: IntComparator, root: &Node{key: 2, left: &Node{key: 1}, right: &Node{key: 2}}}
+    var buf bytes.Buffer
+    SetLogger(log.New(&buf, "", 0))
+    False(unordered.IsSorted(), t)
+    SetLogger(nil)
+    assertPayloadString("IsSorted: 2 is not less than the following key 2\n", buf.String(), t)
+
+    // a comparator changed behind the back of the tree
+    descending := false
+    flipping := NewTreeWith(func(o1, o2 interface{}) int {
+        if descending {
+            return IntComparator(o2, o1)
+        }
+        return IntComparator(o1, o2)
+    })
+    for i := 0; i < 5; i++ {
+        flipping.Put(i, i)
+    }
+    True(flipping.IsSorted(), t)
+    descending = true
+    False(flipping.IsSorted(), t)
+}
+
+func TestToMap(t *testing.T) {
+    m, err := NewTree().ToMap()
+    Nil(err, t)
+    assertEqual(0, uint64(len(m)), t)
+
+    tr := NewTree()
+    for i := 0; i < 5; i++ {
+        tr.Put(i, i*i)
+    }
+    tr.Put(2, "overwritten")
+    m, err = tr.ToMap()
+    Nil(err, t)
+    if expected := map[interface{}]interface{}{0: 0, 1: 1, 2: "overwritten", 3: 9, 4: 16}; !reflect.DeepEqual(expected, m) {
+        t.Errorf("Expected %v got %v", expected, m)
+    }
+
+    // multimaps: the last equal key in key order wins
+    after := func(o1, o2 interface{}) int { return 1 }
+    before := func(o1, o2 interface{}) int { return -1 }
+    appended := NewTreeWith2(IntComparator, after)
+    prepended := NewTreeWith2(IntComparator, before)
+    for _, multi := range []*Tree{appended, prepended} {
+        multi.Put(1, "first")
+        multi.Put(2, "only")
+        multi.Put(1, "last")
+        assertEqual(3, multi.Size(), t)
+    }
+    m, _ = appended.ToMap()
+    if expected := map[interface{}]interface{}{1: "last", 2: "only"}; !reflect.DeepEqual(expected, m) {
+        t.Errorf("Expected %v got %v", expected, m)
+    }
+    m, _ = prepended.ToMap()
+    if expected := map[interface{}]interface{}{1: "first", 2: "only"}; !reflect.DeepEqual(expected, m) {
+        t.Errorf("Expected %v got %v", expected, m)
+    }
+
+    // keys which are not valid map keys fail instead of panicking
+    type tagged struct {
+        Id  int
+        Tag interface{}
+    }
+    byId := func(o1, o2 interface{}) int {
+        return IntComparator(o1.(tagged).Id, o2.(tagged).Id)
+    }
+    structs := NewTreeWith(byId)
+    Nil(structs.Put(tagged{1, "a"}, 1), t)
+    Nil(structs.Put(tagged{2, [2]interface{}{"b", 2}}, 2), t)
+    m, err = structs.ToMap()
+    Nil(err, t)
+    assertEqual(2, uint64(len(m)), t)
+    Nil(structs.Put(tagged{3, []int{3}}, 3), t)
+    m, err = structs.ToMap()
+    True(m == nil && err == ErrorKeyUnhashable && errors.Is(err, ErrorInvalidKey), t)
+}
+
+func TestClosestByValue(t *testing.T) {
+    asFloat := func(v interface{}) float64 { return v.(float64) }
+    _, ok := NewTree().ClosestByValue(1, asFloat)
+    False(ok, t)
+
+    tr := NewTree()
+    for k, v := range []float64{10, -4, 7.5, 3, 7.5, 100} {
+        tr.Put(k, v)
+    }
+    entry, ok := tr.ClosestByValue(4, asFloat)
+    True(ok && entry.Key == 3 && entry.Value == 3.0, t)
+    entry, _ = tr.ClosestByValue(-1000, asFloat)
+    True(entry.Key == 1, t)
+    // 7.5 is stored under both 2 & 4
+    entry, _ = tr.ClosestByValue(8, asFloat)
+    True(entry.Key == 2, t)
+    entry, _ = tr.ClosestByValue(1e9, asFloat)
+    True(entry.Key == 5, t)
+}
+
+// benchmarkDelete empties & refills a tree of benchmarkKeys keys,
+// checking for each key with Has first when `check` is set, the way
+// a delete taking two descents would.
+func benchmarkDelete(b *testing.B, check bool) {
+    tr := NewTree()
+    for i := 0; i < benchmarkKeys; i++ {
+        tr.Put(i, i)
+    }
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        k := i * 7919 % benchmarkKeys
+        if !check || tr.Has(k) {
+            tr.Delete(k)
+        }
+        b.StopTimer()
+        tr.Put(k, k)
+        b.StartTimer()
+    }
+}
+
+func BenchmarkDelete(b *testing.B) {
+    benchmarkDelete(b, false)
+}
+
+func BenchmarkHasThenDelete(b *testing.B) {
+    benchmarkDelete(b, true)
+}
+
+func TestReplicationBatch(t *testing.T) {
+    size := func(v interface{}) int { return len(v.(string)) }
+    entries, cursor, more := NewTree().ReplicationBatch(nil, 10, size)
+    assertEqual(0, uint64(len(entries)), t)
+    True(cursor == nil && !more, t)
+
+    tr := NewTree()
+    for i, v := range []string{"aaa", "bbb", "cccc", "dddddddddddddddd", "e", "ff"} {
+        tr.Put(i, v)
+    }
+    var batches [][]interface{}
+    cursor, more = nil, true
+    for more {
+        entries, cursor, more = tr.ReplicationBatch(cursor, 7, size)
+        batches = append(batches, entryKeys(entries))
+    }
+    // 3+3 fits, 4 fits alone, 16 is oversized & alone, 1+2 fits
+    if expected := [][]interface{}{{0, 1}, {2}, {3}, {4, 5}}; !reflect.DeepEqual(expected, batches) {
+        t.Errorf("Expected %v got %v", expected, batches)
+    }
+    True(cursor == 5, t)
+
+    // exactly full
+    entries, cursor, more = tr.ReplicationBatch(nil, 6, size)
+    True(len(entries) == 2 && cursor == 1 && more, t)
+    entries, _, more = tr.ReplicationBatch(3, 3, size)
+    True(len(entries) == 2 && !more, t)
+}
+
+func TestMergeTrees(t *testing.T) {
+    empty, err := MergeTrees(IntComparator)
+    Nil(err, t)
+    assertEqual(0, empty.Size(), t)
+
+    shards := []*Tree{NewTree(), NewTree(), nil, NewTree()}
+    for i := 0; i < 30; i++ {
+        shards[i%2].Put(i, "even/odd")
+    }
+    for _, k := range []int{0, 15, 40} {
+        shards[3].Put(k, "last")
+    }
+    shards[1].Put(0, "middle")
+
+    merged, err := MergeTrees(IntComparator, shards...)
+    Nil(err, t)
+    assertEqual(31, merged.Size(), t)
+    assertRedBlack(merged, t)
+    for _, k := range []int{0, 15, 40} {
+        ok, payload := merged.Get(k)
+        True(ok && payload == "last", t)
+    }
+    ok, payload := merged.Get(1)
+    True(ok && payload == "even/odd", t)
+    assertEqual(15, shards[0].Size(), t)
+
+    reversed := NewTreeWith(reverseIntComparator)
+    reversed.Put(1, 1)
+    reversed.Put(2, 2)
+    _, err = MergeTrees(IntComparator, shards[0], reversed)
+    True(err == ErrorComparatorMismatch, t)
+}
+
+func TestSiblingUncle(t *testing.T) {
+    tr := NewTree()
+    for _, k := range []int{2, 1, 4, 3} {
+        tr.Put(k, k)
+    }
+    assertEqualTree(tr, t, "((.1.)2((.3.)4.))")
+    node := func(k int) *Node {
+        _, n := tr.getNode(k)
+        return n
+    }
+
+    // root
+    Nil(node(2).Sibling(), t)
+    Nil(node(2).Uncle(), t)
+    // children of the root
+    True(node(1).Sibling() == node(4), t)
+    True(node(4).Sibling() == node(1), t)
+    Nil(node(1).Uncle(), t)
+    // 3 is the single child of 4
+    Nil(node(3).Sibling(), t)
+    True(node(3).Uncle() == node(1), t)
+
+    // a single child whose uncle is a leaf
+    single := NewTree()
+    single.Put(2, 2)
+    single.Put(3, 3)
+    _, three := single.getNode(3)
+    Nil(three.Sibling(), t)
+    Nil(three.Uncle(), t)
+}
+
+func TestLastComparisonCount(t *testing.T) {
+    plain := NewTree()
+    plain.Put(1, 1)
+    plain.Get(1)
+    assertEqual(0, uint64(plain.LastComparisonCount()), t)
+
+    var calls int
+    tr := NewTreeWith(func(o1, o2 interface{}) int {
+        calls++
+        return IntComparator(o1, o2)
+    }, WithComparisonCount())
+    for i := 1; i <= 7; i++ {
+        tr.Put(i, i)
+    }
+    assertEqualTree(tr, t, "((.1.)2((.3.)4((.5.)6(.7.))))")
+
+    for _, tt := range []struct {
+        op       func()
+        expected int
+    }{
+        {func() { tr.Get(2) }, 1},
+        {func() { tr.Get(4) }, 2},
+        {func() { tr.Get(7) }, 4},
+        {func() { tr.Get(100) }, 4},
+        {func() { tr.Has(1) }, 2},
+        {func() { tr.Put(8, 8) }, 4},
+        {func() { tr.Delete(5) }, 3},
+        {func() { tr.GetAndDelete(1) }, 3},
+    } {
+        calls = 0
+        tt.op()
+        assertEqual(uint64(tt.expected), uint64(tr.LastComparisonCount()), t)
+        assertEqual(uint64(calls), uint64(tr.LastComparisonCount()), t)
+    }
+
+    // a Put into an empty tree compares nothing
+    tr.Get(7)
+    True(tr.LastComparisonCount() > 0, t)
+    for _, key := range tr.Keys() {
+        tr.Delete(key)
+    }
+    tr.Put(1, 1)
+    assertEqual(0, uint64(tr.LastComparisonCount()), t)
+}
+
+// Run with -race: lookups must not write to a tree, e.g. to count
+// comparisons without WithComparisonCount or to cache the extremes.
+func TestConcurrentReads(t *testing.T) {
+    tr := NewTree()
+    for i := -1; i <= 1000; i++ {
+        tr.Put(i, i)
+    }
+    // removing the extremes must not leave Min & Max a cache to fill
+    tr.Delete(-1)
+    tr.Delete(1000)
+    var wg sync.WaitGroup
+    for g := 0; g < 4; g++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < 1000; i++ {
+                if ok, _ := tr.Get(i); !ok {
+                    t.Errorf("%d not found", i)
+                }
+                if !tr.Has(i) {
+                    t.Errorf("%d not found", i)
+                }
+                if min, ok := tr.Min(); !ok || min.Key != 0 {
+                    t.Errorf("Expected min 0 got %v", min)
+                }
+                if max, ok := tr.ReadOnly().Max(); !ok || max.Key != 999 {
+                    t.Errorf("Expected max 999 got %v", max)
+                }
+            }
+        }()
+    }
+    wg.Wait()
+}
+
+func TestLongestPrefixMatch(t *testing.T) {
+    tr := NewPrefixTree()
+    _, _, ok := tr.LongestPrefixMatch([]byte("10.0.0.1"))
+    False(ok, t)
+
+    for _, p := range []string{"10.", "10.0.", "10.0.0.", "10.1.", "192.168.", "192.168.1.5"} {
+        tr.Put(p, "via "+p)
+    }
+
+    for _, tt := range []struct {
+        query    string
+        expected string
+        found    bool
+    }{
+        {"10.0.0.1", "10.0.0.", true},
+        {"10.0.1.1", "10.0.", true},
+        {"10.0.", "10.0.", true},
+        {"10.2.3.4", "10.", true},
+        {"10.1.9.9", "10.1.", true},
+        {"192.168.1.50", "192.168.1.5", true},
+        {"192.168.1.4", "192.168.", true},
+        {"192.169.0.1", "", false},
+        {"11.0.0.1", "", false},
+        {"1", "", false},
+    } {
+        prefix, route, ok := tr.LongestPrefixMatch([]byte(tt.query))
+        if ok != tt.found {
+            t.Errorf("%q: expected found %v", tt.query, tt.found)
+            continue
+        }
+        if ok {
+            assertPayloadString(tt.expected, string(prefix), t)
+            assertPayloadString("via "+tt.expected, route.(string), t)
+        }
+    }
+
+    tr.Put("", "default")
+    prefix, route, ok := tr.LongestPrefixMatch([]byte("11.0.0.1"))
+    True(ok, t)
+    assertPayloadString("", string(prefix), t)
+    assertPayloadString("default", route.(string), t)
+}
+
+func TestWithinHeightBound(t *testing.T) {
+    tr := NewTree()
+    True(tr.WithinHeightBound(), t)
+    for i := 1; i <= 1000; i++ {
+        tr.Put(i, i)
+        if !tr.WithinHeightBound() {
+            t.Fatalf("height bound broken after inserting %d", i)
+        }
+    }
+
+    // A hand-built chain of 7 nodes is 7 high, above 2·log2(8) = 6.
+    chain := NewTree()
+    var prev *Node
+    for i := 1; i <= 7; i++ {
+        n := &Node{key: i, color: BLACK}
+        if prev == nil {
+            chain.root = n
+        } else {
+            prev.right, n.parent = n, prev
+        }
+        prev = n
+    }
+    False(chain.WithinHeightBound(), t)
+}
+
+func TestNewViewFromSorted(t *testing.T) {
+    entries := make([]Entry, 0, 100)
+    for i := 0; i < 100; i++ {
+        entries = append(entries, Entry{Key: 2 * i, Value: strconv.Itoa(2 * i)})
+    }
+    tr, err := NewViewFromSorted(IntComparator, entries)
+    Nil(err, t)
+    assertEqual(100, tr.Size(), t)
+    assertEqual(0, uint64(len(tr.Diagnose())), t)
+    True(tr.WithinHeightBound(), t)
+
+    ok, v := tr.Get(42)
+    True(ok, t)
+    assertPayloadString("42", v.(string), t)
+    v, ok = tr.FloorValue(43)
+    True(ok, t)
+    assertPayloadString("42", v.(string), t)
+    var walked []int
+    tr.RangeWalkDescending(10, 16, func(key, _ interface{}) bool {
+        walked = append(walked, key.(int))
+        return true
+    })
+    True(reflect.DeepEqual([]int{16, 14, 12, 10}, walked), t)
+
+    // writes do not reach the slice
+    tr.Put(42, "changed")
+    tr.Delete(0)
+    assertPayloadString("42", entries[21].Value.(string), t)
+    assertEqual(0, uint64(entries[0].Key.(int)), t)
+
+    _, err = NewViewFromSorted(IntComparator, []Entry{{Key: 2}, {Key: 1}})
+    True(err == ErrorKeysOutOfOrder, t)
+    _, err = NewViewFromSorted(IntComparator, []Entry{{Key: 1}, {Key: 1}})
+    True(err == ErrorDuplicateKey, t)
+    _, err = NewViewFromSorted(IntComparator, []Entry{{Key: nil}})
+    True(err == ErrorKeyIsNil, t)
+
+    empty, err := NewViewFromSorted(IntComparator, nil)
+    Nil(err, t)
+    assertEqual(0, empty.Size(), t)
+}
+
+func TestGroupBy(t *testing.T) {
+    tr := NewTree()
+    tr.GroupBy(func(key interface{}) interface{} { return key }, func(interface{}, []Entry) {
+        t.Error("no group expected on an empty tree")
+    })
+
+    // minutes since midnight, bucketed by hour
+    for _, minute := range []int{5, 59, 60, 61, 130, 300, 301, 359} {
+        tr.Put(minute, minute)
+    }
+    var hours []int
+    var sizes []int
+    tr.GroupBy(func(key interface{}) interface{} {
+        return key.(int) / 60
+    }, func(bucket interface{}, entries []Entry) {
+        hours = append(hours, bucket.(int))
+        sizes = append(sizes, len(entries))
+        for _, e := range entries {
+            assertEqual(uint64(bucket.(int)), uint64(e.Key.(int)/60), t)
+        }
+    })
+    True(reflect.DeepEqual([]int{0, 1, 2, 5}, hours), t)
+    True(reflect.DeepEqual([]int{2, 2, 1, 3}, sizes), t)
+}
+
+func TestErrorCategories(t *testing.T) {
+    for _, err := range []error{ErrorKeyIsNil, ErrorKeyDisallowed, ErrorKeyIncomparable, ErrorKeyNotInt, ErrorKeyTypeUnsupported} {
+        True(errors.Is(err, ErrorInvalidKey), t)
+        False(errors.Is(err, ErrorInvalidOperation), t)
+    }
+    for _, err := range []error{ErrorLengthMismatch, ErrorUnknownOperation, ErrorNilValue, ErrorKeysOutOfOrder,
+        ErrorNodeNotInTree, ErrorDuplicateKey, ErrorBrokenLink, ErrorComparatorMismatch} {
+        True(errors.Is(err, ErrorInvalidOperation), t)
+        False(errors.Is(err, ErrorInvalidKey), t)
+    }
+    assertPayloadString("The literal nil not allowed as keys", ErrorKeyIsNil.Error(), t)
+
+    // errors returned by the API keep their identity & category
+    _, err := NewTreeFromSorted(IntComparator, []interface{}{nil}, []interface{}{1}, KeepLast)
+    True(errors.Is(err, ErrorKeyIsNil), t)
+    True(errors.Is(err, ErrorInvalidKey), t)
+    _, err = NewTreeFromSorted(IntComparator, []interface{}{[]int{1}}, []interface{}{1}, KeepLast)
+    True(errors.Is(err, ErrorKeyDisallowed), t)
+    True(errors.Is(err, ErrorInvalidKey), t)
+    _, err = NewViewFromSorted(IntComparator, []Entry{{Key: 2}, {Key: 1}})
+    True(errors.Is(err, ErrorInvalidOperation), t)
+
+    // wrapped errors still match both
+    tr := NewTree()
+    tr.Put(1, 1)
+    tr.root.left = &Node{key: 0, parent: tr.root}
+    tr.root.left.parent = &Node{key: 99}
+    err = tr.CheckConnectivity()
+    True(errors.Is(err, ErrorBrokenLink), t)
+    True(errors.Is(err, ErrorInvalidOperation), t)
+}
+
+func TestDiff(t *testing.T) {
+    from, to := NewTree(), NewTree()
+    for _, k := range []int{1, 2, 3, 5, 8} {
+        from.Put(k, strconv.Itoa(k))
+    }
+    for _, k := range []int{2, 3, 4, 8, 9, 10} {
+        to.Put(k, strconv.Itoa(k))
+    }
+    to.Put(3, "three")
+
+    keysOf := func(entries []Entry) []int {
+        keys := []int{}
+        for _, e := range entries {
+            keys = append(keys, e.Key.(int))
+        }
+        return keys
+    }
+    eq := func(a, b interface{}) bool { return a == b }
+
+    added, removed, changed := Diff(from, to, eq)
+    True(reflect.DeepEqual([]int{4, 9, 10}, keysOf(added)), t)
+    True(reflect.DeepEqual([]int{1, 5}, keysOf(removed)), t)
+    True(reflect.DeepEqual([]int{3}, keysOf(changed)), t)
+    assertPayloadString("three", changed[0].Value.(string), t)
+
+    _, _, changed = Diff(from, to, nil)
+    assertEqual(0, uint64(len(changed)), t)
+
+    added, removed, changed = Diff(from, from, eq)
+    assertEqual(0, uint64(len(added)+len(removed)+len(changed)), t)
+
+    added, removed, _ = Diff(nil, to, eq)
+    assertEqual(to.Size(), uint64(len(added)), t)
+    assertEqual(0, uint64(len(removed)), t)
+    added, removed, _ = Diff(from, nil, eq)
+    assertEqual(0, uint64(len(added)), t)
+    assertEqual(from.Size(), uint64(len(removed)), t)
+}
+
+func TestKeyKind(t *testing.T) {
+    tr := NewTreeWith(func(o1, o2 interface{}) int {
+        return StringComparator(fmt.Sprint(o1), fmt.Sprint(o2))
+    })
+    _, ok := tr.KeyKind()
+    False(ok, t)
+    tr.Put(1, 1)
+    tr.Put(2, 2)
+    kind, ok := tr.KeyKind()
+    True(ok, t)
+    True(kind == reflect.Int, t)
+    // heterogeneous but comparable keys are accepted without the option
+    Nil(tr.Put("3", 3), t)
+    _, ok = tr.KeyKind()
+    False(ok, t)
+
+    strict := NewTreeWith(IntComparator, WithSameKeyKind())
+    Nil(strict.Put(1, 1), t)
+    Nil(strict.Put(2, 2), t)
+    err := strict.Put("3", 3)
+    True(err == ErrorKeyKindMismatch, t)
+    True(errors.Is(err, ErrorInvalidKey), t)
+    err = strict.Put(3.0, 3)
+    True(err == ErrorKeyKindMismatch, t)
+    assertEqual(2, strict.Size(), t)
+    kind, ok = strict.KeyKind()
+    True(ok, t)
+    True(kind == reflect.Int, t)
+
+    // the first key of an empty tree sets the kind
+    strict = NewTreeWith(StringComparator, WithSameKeyKind())
+    Nil(strict.Put("a", 1), t)
+    True(strict.Put(1, 1) == ErrorKeyKindMismatch, t)
+}
+
+func TestWalkInsertionOrder(t *testing.T) {
+    collect := func(tr *Tree, limit int) []int {
+        keys := []int{}
+        tr.WalkInsertionOrder(func(key, _ interface{}) bool {
+            keys = append(keys, key.(int))
+            return len(keys) < limit
+        })
+        return keys
+    }
+
+    tr := NewTreeWith(IntComparator, WithInsertionSeq(false))
+    assertEqual(0, uint64(len(collect(tr, 10))), t)
+    for _, k := range []int{5, 1, 9, 3, 7} {
+        tr.Put(k, k)
+    }
+    True(reflect.DeepEqual([]int{5, 1, 9, 3, 7}, collect(tr, 10)), t)
+    True(reflect.DeepEqual([]int{5, 1}, collect(tr, 2)), t)
+
+    // an overwrite keeps the first sequence number unless refreshed
+    tr.Put(5, 50)
+    True(reflect.DeepEqual([]int{5, 1, 9, 3, 7}, collect(tr, 10)), t)
+    refreshed := NewTreeWith(IntComparator, WithInsertionSeq(true))
+    for _, k := range []int{5, 1, 9, 5} {
+        refreshed.Put(k, k)
+    }
+    True(reflect.DeepEqual([]int{1, 9, 5}, collect(refreshed, 10)), t)
+
+    // without insertion sequence numbers, key order
+    plain := NewTree()
+    for _, k := range []int{5, 1, 9} {
+        plain.Put(k, k)
+    }
+    True(reflect.DeepEqual([]int{1, 5, 9}, collect(plain, 10)), t)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import (
+    "reflect"
+    "regexp"
+    "runtime"
+    "sync"
+)
+
+// `registryLock` protects `comparators`
+var registryLock sync.RWMutex
+
+// comparators maps names to comparators, so that a tree saved along with
+// the name of its comparator can be restored with the same ordering.
+var comparators = map[string]Comparator{
+    "int":     IntComparator,
+    "string":  StringComparator,
+    "float64": Float64Comparator,
+}
+
+// RegisterComparator makes `c` available under `name` to LookupComparator
+// & ComparatorName, replacing any comparator registered under that name.
+// The built-in comparators are registered as "int", "string" & "float64".
+// Packages with custom comparators typically register them in `init`.
+func RegisterComparator(name string, c Comparator) {
+    registryLock.Lock()
+    defer registryLock.Unlock()
+    comparators[name] = c
+}
+
+// LookupComparator returns the comparator registered under `name`.
+func LookupComparator(name string) (Comparator, bool) {
+    registryLock.RLock()
+    defer registryLock.RUnlock()
+    c, ok := comparators[name]
+    return c, ok
+}
+
+// registered returns the comparator registered under `name`, or nil, for
+// the Go source written by GoLiteral.
+func registered(name string) Comparator {
+    c, _ := LookupComparator(name)
+    return c
+}
+
+// closureName matches the names the runtime gives to function literals &
+// method values, whose code is shared by every closure they make.
+var closureName = regexp.MustCompile(`\.func\d+(\.\d+)*$|-fm$`)
+
+// ComparatorName returns the name under which `c` was registered. Functions
+// can only be told apart by their code, which all the closures made by one
+// function share, e.g. every comparator returned by ComparatorByField. So
+// it returns false for a closure, as well as for a function registered
+// under several names. To keep the name of any comparator, closures
+// included, create the tree with NewTreeNamed & see Tree.ComparatorName.
+func ComparatorName(c Comparator) (string, bool) {
+    if c == nil {
+        return "", false
+    }
+    pointer := reflect.ValueOf(c).Pointer()
+    if f := runtime.FuncForPC(pointer); f == nil || closureName.MatchString(f.Name()) {
+        return "", false
+    }
+    registryLock.RLock()
+    defer registryLock.RUnlock()
+    found := ""
+    for name, registered := range comparators {
+        if reflect.ValueOf(registered).Pointer() == pointer {
+            if found != "" {
+                return "", false
+            }
+            found = name
+        }
+    }
+    return found, found != ""
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+// OpKind tells which mutation an Operation records.
+type OpKind byte
+
+const (
+    PUT OpKind = iota
+    DELETE
+)
+
+func (k OpKind) String() string {
+    switch k {
+    case PUT:
+        return "put"
+    case DELETE:
+        return "delete"
+    default:
+        return "not recognized"
+    }
+}
+
+// Operation is a single mutation of a tree, as kept in an
+// append-only (write-ahead) log.
+type Operation struct {
+    Kind  OpKind
+    Key   interface{}
+    Value interface{} // unused by DELETE
+}
+
+// ReplayLog rebuilds a tree ordered by `cmp` by applying `ops` in turn.
+// Replaying the log of every mutation made to a tree yields an equal tree.
+// Replay stops at the first operation that fails, returning its error;
+// an unknown OpKind fails with ErrorUnknownOperation.
+func ReplayLog(cmp Comparator, ops []Operation) (*Tree, error) {
+    t := NewTreeWith(cmp)
+    for _, op := range ops {
+        switch op.Kind {
+        case PUT:
+            if err := t.Put(op.Key, op.Value); err != nil {
+                return nil, err
+            }
+        case DELETE:
+            if err := mustBeValidKey(op.Key); err != nil {
+                return nil, err
+            }
+            t.Delete(op.Key)
+        default:
+            return nil, ErrorUnknownOperation
+        }
+    }
+    return t, nil
+}
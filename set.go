@@ -0,0 +1,73 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+// Set is an ordered set of keys backed by a Tree. Every key maps
+// to a nil payload.
+type Set struct {
+    tree *Tree
+}
+
+// NewSet returns an empty Set of `int` keys ordered by `IntComparator`.
+func NewSet(options ...Option) *Set {
+    return NewSetWith(IntComparator, options...)
+}
+
+// NewSetWith returns an empty Set ordered by the supplied `Comparator`.
+// RejectNilValue is ignored, since every key of a Set maps to nil.
+func NewSetWith(c Comparator, options ...Option) *Set {
+    tree := NewTreeWith(c, options...)
+    tree.rejectNil = false
+    return &Set{tree: tree}
+}
+
+// Add inserts `key` & reports whether it was absent before.
+// Keys which are not allowed in a Tree are never added.
+func (s *Set) Add(key interface{}) bool {
+    if s.tree.Has(key) {
+        return false
+    }
+    return s.tree.Put(key, nil) == nil
+}
+
+// Remove deletes `key` & reports whether it was present.
+func (s *Set) Remove(key interface{}) bool {
+    if !s.tree.Has(key) {
+        return false
+    }
+    s.tree.Delete(key)
+    return true
+}
+
+// Contains checks for the existence of `key`.
+func (s *Set) Contains(key interface{}) bool {
+    return s.tree.Has(key)
+}
+
+// Size returns the number of keys in the set.
+func (s *Set) Size() uint64 {
+    return s.tree.Size()
+}
+
+// Each calls `fn` on every key in ascending order,
+// stopping early when `fn` returns false.
+func (s *Set) Each(fn func(key interface{}) bool) {
+    inorder(s.tree.root, func(n *Node) bool {
+        return fn(n.key)
+    })
+}
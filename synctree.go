@@ -0,0 +1,91 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package redblacktree
+
+import (
+    "sync"
+)
+
+// SyncTree guards a Tree with a mutex, so that it can be shared between
+// goroutines. Each method holds the lock for a single operation of the
+// tree; PutIf & GetAndDelete thus make conditional updates atomic.
+type SyncTree struct {
+    mu   sync.Mutex
+    tree *Tree
+}
+
+// NewSyncTree returns an empty SyncTree ordered by the supplied
+// `Comparator` & configured by `options` as in NewTreeWith.
+func NewSyncTree(c Comparator, options ...Option) *SyncTree {
+    return &SyncTree{tree: NewTreeWith(c, options...)}
+}
+
+// Put is Tree.Put under the lock.
+func (st *SyncTree) Put(key, data interface{}) error {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    return st.tree.Put(key, data)
+}
+
+// PutIf is Tree.PutIf under the lock. `cond` runs with the lock held,
+// so it must not call the SyncTree.
+func (st *SyncTree) PutIf(key, data interface{}, cond func(old interface{}, existed bool) bool) bool {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    return st.tree.PutIf(key, data, cond)
+}
+
+// Get is Tree.Get under the lock.
+func (st *SyncTree) Get(key interface{}) (bool, interface{}) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    return st.tree.Get(key)
+}
+
+// Delete is Tree.Delete under the lock.
+func (st *SyncTree) Delete(key interface{}) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    st.tree.Delete(key)
+}
+
+// GetAndDelete is Tree.GetAndDelete under the lock.
+func (st *SyncTree) GetAndDelete(key interface{}) (interface{}, bool) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    return st.tree.GetAndDelete(key)
+}
+
+// Size is Tree.Size under the lock.
+func (st *SyncTree) Size() uint64 {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    return st.tree.Size()
+}
+
+// ForEachSnapshot calls `fn` on every entry in ascending key order,
+// stopping early when `fn` returns false. The tree is cloned under the
+// lock, which is released before iterating over the clone, so writers
+// are only blocked for the copy, at the cost of its memory. Writes made
+// during the iteration are therefore not seen. `fn` may call the SyncTree.
+func (st *SyncTree) ForEachSnapshot(fn func(key, value interface{}) bool) {
+    st.mu.Lock()
+    snapshot := st.tree.Clone()
+    st.mu.Unlock()
+    snapshot.Each(fn)
+}